@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/nomad/drivers/docker"
+)
+
+// dockerAuthFile is the -docker-auth file. It either holds the registry
+// credentials for the task image or names a docker credentials helper the
+// driver runs to get them:
+//
+//	{"username": "bot", "password": "secret", "server_address": "registry.example.com"}
+//	{"helper": "ecr-login"}
+type dockerAuthFile struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	Email         string `json:"email"`
+	ServerAddress string `json:"server_address"`
+	Helper        string `json:"helper"`
+}
+
+// dockerAuth is where the image pull gets its credentials from: the task
+// config for credentials, the driver config for a helper or a docker config
+// file.
+type dockerAuth struct {
+	task   docker.DockerAuth
+	driver docker.AuthConfig
+}
+
+// loadDockerAuth reads the -docker-auth file in path. Without one the docker
+// config.json in DOCKER_CONFIG is used, if that is set. No auth at all leaves
+// both empty so public images are pulled as before.
+func loadDockerAuth(path string) (dockerAuth, error) {
+	var auth dockerAuth
+	if path == "" {
+		if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+			auth.driver.Config = filepath.Join(dir, "config.json")
+		}
+		return auth, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return auth, err
+	}
+	var f dockerAuthFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return auth, fmt.Errorf("failed to decode docker auth file %s: %v", path, err)
+	}
+
+	switch {
+	case f.Helper != "" && (f.Username != "" || f.Password != ""):
+		return auth, fmt.Errorf("docker auth file %s must hold either credentials or a helper, not both", path)
+	case f.Helper != "":
+		auth.driver.Helper = f.Helper
+	case f.Username == "" || f.Password == "":
+		return auth, fmt.Errorf("docker auth file %s needs a username and password, or a helper", path)
+	default:
+		auth.task = docker.DockerAuth{
+			Username:   f.Username,
+			Password:   f.Password,
+			Email:      f.Email,
+			ServerAddr: f.ServerAddress,
+		}
+	}
+	return auth, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/helper/uuid"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// benchResult is the latency of one start and stop cycle of runBench.
+type benchResult struct {
+	start time.Duration
+	stop  time.Duration
+}
+
+// runBench starts and stops n copies of task one after the other against the
+// plugin client dh already holds, and writes their start and stop latency
+// next to spawn, the time launching the plugin and dispensing its driver
+// took. That is the cost each task would pay with a plugin per task. Only
+// StartTask and the StopTask and DestroyTask of a running task are timed,
+// building the alloc dirs is not. How each started task ended is returned
+// for the run result, up to the first that failed.
+func runBench(ctx context.Context, w io.Writer, dh *harness.DriverHarness, task *drivers.TaskConfig, taskCfg docker.TaskConfig, n int, spawn, grace time.Duration) ([]shutdownResult, error) {
+	results := make([]benchResult, 0, n)
+	ended := make([]shutdownResult, 0, n)
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		t := task.Copy()
+		t.ID = uuid.Generate()
+		t.AllocID = uuid.Generate()
+		t.Name = fmt.Sprintf("%s-%d", task.Name, i)
+		dh.EncodeTaskConfig(t, taskCfg)
+
+		r, err := benchTask(dh, t, taskCfg, grace)
+		if err != nil {
+			ended = append(ended, shutdownResult{TaskID: t.ID, Name: t.Name, Outcome: outcomeFailed, Err: err})
+			return ended, fmt.Errorf("task %d of %d: %v", i+1, n, err)
+		}
+		results = append(results, r)
+		ended = append(ended, shutdownResult{TaskID: t.ID, Name: t.Name, Outcome: outcomeStopped})
+	}
+
+	printBench(w, results, spawn)
+	return ended, nil
+}
+
+// benchTask runs one start and stop cycle of t in its own alloc dir.
+func benchTask(dh *harness.DriverHarness, t *drivers.TaskConfig, taskCfg docker.TaskConfig, grace time.Duration) (benchResult, error) {
+	var r benchResult
+
+	cleanup, err := dh.MkAllocDir(t, true, "", "")
+	if err != nil {
+		return r, err
+	}
+	defer cleanup()
+
+	taskCfg.Args = expandTaskEnv(taskCfg.Args, t.Env)
+	dh.EncodeTaskConfig(t, taskCfg)
+
+	start := time.Now()
+	if _, _, err := dh.StartTask(t); err != nil {
+		return r, fmt.Errorf("failed to start task: %v", err)
+	}
+	r.start = time.Since(start)
+
+	start = time.Now()
+	stopErr := dh.StopTask(t.ID, grace, "SIGINT")
+	if err := dh.DestroyTask(t.ID, true); err != nil {
+		return r, fmt.Errorf("failed to destroy task: %v", err)
+	}
+	r.stop = time.Since(start)
+	if stopErr != nil {
+		return r, fmt.Errorf("failed to stop task: %v", stopErr)
+	}
+	return r, nil
+}
+
+// printBench writes the mean, median and worst start and stop latency of
+// results and the plugin spawn time they no longer pay.
+func printBench(w io.Writer, results []benchResult, spawn time.Duration) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "tasks\t%d\n", len(results))
+	fmt.Fprintf(tw, "plugin spawn\t%s\t(once, instead of per task)\n", spawn.Round(time.Microsecond))
+	if len(results) == 0 {
+		return
+	}
+
+	starts := make([]time.Duration, len(results))
+	stops := make([]time.Duration, len(results))
+	for i, r := range results {
+		starts[i], stops[i] = r.start, r.stop
+	}
+
+	fmt.Fprintln(tw, "\nCALL\tMEAN\tP50\tMAX")
+	for _, row := range []struct {
+		name string
+		ds   []time.Duration
+	}{
+		{"start", starts},
+		{"stop", stops},
+	} {
+		mean, p50, max := latencyStats(row.ds)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", row.name, mean.Round(time.Microsecond), p50.Round(time.Microsecond), max.Round(time.Microsecond))
+	}
+}
+
+// latencyStats returns the mean, median and maximum of ds, which must not be
+// empty. ds is sorted in place.
+func latencyStats(ds []time.Duration) (mean, p50, max time.Duration) {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	var total time.Duration
+	for _, d := range ds {
+		total += d
+	}
+	return total / time.Duration(len(ds)), ds[len(ds)/2], ds[len(ds)-1]
+}
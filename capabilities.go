@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// printCapabilities writes the driver capabilities and the attributes of its
+// fingerprint as a table.
+func printCapabilities(w io.Writer, caps *drivers.Capabilities, fp *drivers.Fingerprint) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	netModes := make([]string, 0, len(caps.NetIsolationModes))
+	for _, m := range caps.NetIsolationModes {
+		netModes = append(netModes, string(m))
+	}
+
+	fmt.Fprintln(tw, "CAPABILITY\tVALUE")
+	fmt.Fprintf(tw, "send signals\t%s\n", yesNo(caps.SendSignals))
+	fmt.Fprintf(tw, "exec\t%s\n", yesNo(caps.Exec))
+	fmt.Fprintf(tw, "fs isolation\t%s\n", caps.FSIsolation)
+	fmt.Fprintf(tw, "network isolation modes\t%s\n", strings.Join(netModes, ", "))
+	fmt.Fprintf(tw, "must initiate network\t%s\n", yesNo(caps.MustInitiateNetwork))
+	fmt.Fprintf(tw, "mount configs\t%s\n", mountConfigName(caps.MountConfigs))
+	fmt.Fprintf(tw, "remote tasks\t%s\n", yesNo(caps.RemoteTasks))
+
+	if fp == nil {
+		return
+	}
+
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "FINGERPRINT\tVALUE")
+	fmt.Fprintf(tw, "health\t%s\n", fp.Health)
+	fmt.Fprintf(tw, "description\t%s\n", fp.HealthDescription)
+
+	keys := make([]string, 0, len(fp.Attributes))
+	for k := range fp.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(tw, "%s\t%s\n", k, fp.Attributes[k].GoString())
+	}
+}
+
+// logCapabilities logs what printCapabilities prints, with the fingerprint
+// attributes as fields.
+func logCapabilities(logger hclog.Logger, caps *drivers.Capabilities, fp *drivers.Fingerprint) {
+	logger.Info("driver capabilities",
+		"fs_isolation", caps.FSIsolation,
+		"exec", caps.Exec,
+		"send_signals", caps.SendSignals,
+		"mount_configs", mountConfigName(caps.MountConfigs))
+
+	if fp == nil {
+		return
+	}
+
+	args := []interface{}{"health", fp.Health, "description", fp.HealthDescription}
+	for k, v := range fp.Attributes {
+		args = append(args, k, v.GoString())
+	}
+	logger.Info("driver fingerprint", args...)
+}
+
+// firstFingerprint returns the first fingerprint the driver sends.
+func firstFingerprint(ctx context.Context, d drivers.DriverPlugin) (*drivers.Fingerprint, error) {
+	ch, err := d.Fingerprint(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case fp, ok := <-ch:
+		if !ok || fp == nil {
+			return nil, fmt.Errorf("fingerprint stream closed")
+		}
+		if fp.Err != nil {
+			return nil, fp.Err
+		}
+		return fp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out waiting for fingerprint")
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+func mountConfigName(m drivers.MountConfigSupport) string {
+	switch m {
+	case drivers.MountConfigSupportAll:
+		return "all"
+	case drivers.MountConfigSupportNone:
+		return "none"
+	default:
+		return fmt.Sprintf("unknown (%d)", m)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	plugin "github.com/hashicorp/go-plugin"
+)
+
+// pluginChecksum is the SHA-256 the plugin binary must have before it is
+// launched, set from -plugin-checksum or the .sha256 file next to the
+// binary. Empty launches the plugin unchecked.
+var pluginChecksum []byte
+
+// checksumFileSuffix names the file next to the plugin binary holding its
+// expected checksum, in sha256sum format.
+const checksumFileSuffix = ".sha256"
+
+// loadPluginChecksum decodes the hex SHA-256 given with -plugin-checksum. If
+// none is given it is read from the .sha256 file next to path, if there is
+// one.
+func loadPluginChecksum(flagValue, path string) ([]byte, error) {
+	value := flagValue
+	if value == "" {
+		b, err := ioutil.ReadFile(path + checksumFileSuffix)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// sha256sum writes "<checksum>  <file>"
+		fields := strings.Fields(string(b))
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("checksum file %s%s is empty", path, checksumFileSuffix)
+		}
+		value = fields[0]
+	}
+
+	sum, err := hex.DecodeString(value)
+	if err != nil || len(sum) != 32 {
+		return nil, fmt.Errorf("invalid plugin checksum %q, expected a hex SHA-256", value)
+	}
+	return sum, nil
+}
+
+// checksumError is a plugin binary which does not match the expected
+// checksum. It was not launched.
+type checksumError struct {
+	err error
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("security error: plugin %s does not match its expected SHA-256 %x, refusing to launch it", pluginPath, pluginChecksum)
+}
+
+func (e *checksumError) Unwrap() error {
+	return e.err
+}
+
+// wrapChecksumError turns the go-plugin checksum mismatch into a
+// *checksumError. Other errors are returned as is.
+func wrapChecksumError(err error) error {
+	if errors.Is(err, plugin.ErrChecksumsDoNotMatch) {
+		return &checksumError{err: err}
+	}
+	return err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/helper/uuid"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// copyRun is one of the task copies started by runCopies.
+type copyRun struct {
+	task    *drivers.TaskConfig
+	cleanup func()
+	err     error
+}
+
+// runCopies starts count copies of task concurrently, each in its own alloc
+// dir, and waits until all of them exited or ctx is done. Every copy is then
+// shut down and reported, copies which failed to start are reported with
+// their start error. The alloc dirs of all copies are removed before
+// returning, whether or not they started. Their state changes are reported
+// to lifecycle.
+func runCopies(ctx context.Context, logger hclog.Logger, dh *harness.DriverHarness, lifecycle *lifecycleNotifier, task *drivers.TaskConfig, taskCfg docker.TaskConfig, count int, stdoutFile, stderrFile string, grace time.Duration, pullRetries int) []shutdownResult {
+	runs := make([]*copyRun, count)
+
+	var wg sync.WaitGroup
+	for i := range runs {
+		copyTask := task.Copy()
+		copyTask.ID = uuid.Generate()
+		copyTask.AllocID = uuid.Generate()
+		copyTask.Name = fmt.Sprintf("%s-%d", task.Name, i)
+		runs[i] = &copyRun{task: copyTask}
+
+		wg.Add(1)
+		go func(r *copyRun) {
+			defer wg.Done()
+			r.cleanup, r.err = startCopy(dh, logger, r.task, taskCfg, stdoutFile, stderrFile, pullRetries)
+		}(runs[i])
+	}
+	wg.Wait()
+
+	defer func() {
+		for _, r := range runs {
+			if r.cleanup != nil {
+				r.cleanup()
+			}
+		}
+	}()
+
+	var started []*drivers.TaskConfig
+	for _, r := range runs {
+		if r.err != nil {
+			logger.Error("failed to start task copy", "task", r.task.Name, "error", r.err)
+			lifecycle.transition(r.task, stateFailed, nil, r.err)
+			continue
+		}
+		lifecycle.transition(r.task, stateStarted, nil, nil)
+		started = append(started, r.task)
+	}
+	fmt.Printf("started %d of %d tasks\n", len(started), count)
+
+	for _, t := range started {
+		wg.Add(1)
+		go func(t *drivers.TaskConfig) {
+			defer wg.Done()
+			res, err := dh.WaitTask(ctx, t.ID)
+			switch {
+			case err == nil:
+				lifecycle.transition(t, stateExited, res, nil)
+			case ctx.Err() == nil:
+				logger.Error("failed to wait on task", "task", t.Name, "error", err)
+			}
+		}(t)
+	}
+	wg.Wait()
+
+	results := Shutdown(context.Background(), dh, started, grace, "SIGINT")
+	lifecycle.shutdown(results)
+	for _, r := range runs {
+		if r.err != nil {
+			results = append(results, shutdownResult{
+				TaskID:  r.task.ID,
+				Name:    r.task.Name,
+				Outcome: outcomeFailed,
+				Err:     r.err,
+			})
+		}
+	}
+	return results
+}
+
+// startCopy builds the alloc dir of task and starts it. The returned
+// cleanup removes the alloc dir and is set even if the start failed.
+func startCopy(dh *harness.DriverHarness, logger hclog.Logger, task *drivers.TaskConfig, taskCfg docker.TaskConfig, stdoutFile, stderrFile string, pullRetries int) (func(), error) {
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	cleanup, err := dh.MkAllocDir(task, true, stdoutFile, stderrFile)
+	if err != nil {
+		return nil, err
+	}
+
+	taskCfg.Args = expandTaskEnv(taskCfg.Args, task.Env)
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	if _, _, err := startTaskWithPullRetries(dh, logger, task, pullRetries); err != nil {
+		return cleanup, fmt.Errorf("failed to start task: %v", err)
+	}
+	return cleanup, nil
+}
+
+// copiesExitCode returns the first non-zero exit code of the copies, 1 for a
+// copy without an exit result, or 0 if all of them exited cleanly.
+func copiesExitCode(results []shutdownResult) int {
+	for _, r := range results {
+		if code := taskExitCode([]shutdownResult{r}, r.TaskID); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// loadDriverConfig reads the docker plugin config, the body of the plugin's
+// config block in the Nomad client config, from the HCL or JSON file in path
+// and decodes it with the config schema of d. An empty path returns nil.
+func loadDriverConfig(d drivers.DriverPlugin, path string) (*docker.DriverConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	schema, err := d.ConfigSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get driver config schema: %v", err)
+	}
+	if schema == nil {
+		return nil, fmt.Errorf("driver config %s: the driver takes no config", path)
+	}
+
+	var cfg docker.DriverConfig
+	if err := decodeConfigFile(path, "driver config", schema, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// newDriverConfig returns the plugin config passed to SetConfig, starting
+// from file, the -driver-config contents, if not nil. A non-empty endpoint
+// replaces its docker endpoint, volumes enables host path mounts, which the
+// driver refuses by default, and a non-empty auth replaces where image pulls
+// look up registry credentials. With none of them the config is left empty
+// so the driver falls back to DOCKER_HOST or the local socket.
+func newDriverConfig(file *docker.DriverConfig, endpoint string, volumes bool, auth docker.AuthConfig) (*base.Config, error) {
+	if file == nil && endpoint == "" && !volumes && auth == (docker.AuthConfig{}) {
+		return &base.Config{}, nil
+	}
+
+	var cfg docker.DriverConfig
+	if file != nil {
+		cfg = *file
+	}
+	if endpoint != "" {
+		cfg.Endpoint = endpoint
+	}
+	if volumes {
+		cfg.Volumes.Enabled = true
+	}
+	if auth != (docker.AuthConfig{}) {
+		cfg.Auth = auth
+	}
+	if cfg.Endpoint != "" {
+		if err := validateDockerEndpoint(cfg.Endpoint); err != nil {
+			return nil, err
+		}
+	}
+
+	var data []byte
+	if err := base.MsgPackEncode(&data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to encode driver config: %v", err)
+	}
+
+	return &base.Config{PluginConfig: data}, nil
+}
+
+// validateDockerEndpoint checks the scheme of a docker endpoint URI.
+func validateDockerEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid docker endpoint %q: %v", endpoint, err)
+	}
+
+	switch u.Scheme {
+	case "unix", "tcp":
+		return nil
+	case "ssh":
+		// The fsouza docker client used by the driver cannot dial ssh,
+		// forward the remote socket and use unix:// or tcp:// instead.
+		return fmt.Errorf("docker endpoint %q: ssh is not supported by the docker driver, use an ssh tunnel to a unix or tcp endpoint", endpoint)
+	default:
+		return fmt.Errorf("docker endpoint %q must use the unix:// or tcp:// scheme", endpoint)
+	}
+}
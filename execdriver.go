@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclspecutils"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/zclconf/go-cty/cty/msgpack"
+)
+
+// commandDrivers run the task command as a process on the host instead of in
+// a docker image. Their task configs only hold the command and its args.
+var commandDrivers = map[string]bool{
+	"exec":     true,
+	"raw_exec": true,
+}
+
+// dockerOnlyFlags configure docker features command drivers do not have.
+var dockerOnlyFlags = []string{
+	"load-image", "docker-auth", "docker-host", "driver-config", "volume",
+	"sysctl", "pids-limit", "port", "network-mode", "sidecar",
+}
+
+// commandDriverConfig returns the plugin config of the command driver d: the
+// defaults of its config schema, the way Nomad fills in a plugin without a
+// config block. raw_exec is enabled, Nomad leaves that to the client config.
+func commandDriverConfig(d drivers.DriverPlugin, name string) (*base.Config, error) {
+	schema, err := d.ConfigSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get driver config schema: %v", err)
+	}
+	spec, diags := hclspecutils.Convert(schema)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid driver config schema: %v", diags)
+	}
+
+	body := map[string]interface{}{}
+	if name == "raw_exec" {
+		body["enabled"] = true
+	}
+	val, diags, errs := hclutils.ParseHclInterface(body, spec, nil)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("failed to build %s driver config: %v", name, errs)
+	}
+
+	data, err := msgpack.Marshal(val, hcldec.ImpliedType(spec))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode driver config: %v", err)
+	}
+	return &base.Config{PluginConfig: data}, nil
+}
+
+// checkCommandDriverFlags rejects the docker only flags for the command
+// driver name.
+func checkCommandDriverFlags(name string) error {
+	for _, f := range dockerOnlyFlags {
+		if isFlagSet(f) {
+			return fmt.Errorf("-%s cannot be used with -driver %s", f, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	docker "github.com/fsouza/go-dockerclient"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// dockerLabelAllocID is the label the docker driver sets to the alloc ID of
+// the task on its container.
+const dockerLabelAllocID = "com.hashicorp.nomad.alloc_id"
+
+// gcStopTimeout is how many seconds a leftover container is given to stop
+// before it is killed.
+const gcStopTimeout = 5
+
+// gcResult is one alloc dir or container found by the GC.
+type gcResult struct {
+	Kind    string
+	ID      string
+	AllocID string
+	Err     error
+}
+
+// runGC removes the alloc dirs and, with containers set, the docker
+// containers earlier harness runs left behind, skipping those of runs which
+// are still alive. endpoint is the docker endpoint, DOCKER_HOST or the local
+// socket if empty. With dryRun nothing is removed.
+func runGC(ctx context.Context, logger hclog.Logger, containers bool, endpoint string, dryRun bool) ([]gcResult, error) {
+	dirs, err := harness.FindAllocDirs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find alloc dirs: %v", err)
+	}
+
+	var results []gcResult
+	liveAllocs := make(map[string]bool)
+	for _, dir := range dirs {
+		if dir.Live {
+			liveAllocs[dir.AllocID] = true
+			continue
+		}
+
+		r := gcResult{Kind: "alloc dir", ID: dir.Path, AllocID: dir.AllocID}
+		if !dryRun {
+			r.Err = harness.RemoveAllocDir(logger, dir.Path)
+		}
+		results = append(results, r)
+	}
+
+	if !containers {
+		return results, nil
+	}
+
+	client, err := newDockerClient(endpoint)
+	if err != nil {
+		return results, fmt.Errorf("failed to connect to docker: %v", err)
+	}
+	list, err := client.ListContainers(docker.ListContainersOptions{
+		All:     true,
+		Filters: map[string][]string{"label": {harness.ManagedByLabel + "=" + harness.ManagedBy}},
+		Context: ctx,
+	})
+	if err != nil {
+		return results, fmt.Errorf("failed to list containers: %v", err)
+	}
+
+	for _, c := range list {
+		allocID := c.Labels[dockerLabelAllocID]
+		if liveAllocs[allocID] {
+			continue
+		}
+
+		r := gcResult{Kind: "container", ID: c.ID, AllocID: allocID}
+		if !dryRun {
+			r.Err = removeContainer(ctx, client, c.ID)
+		}
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// newDockerClient connects to endpoint, or DOCKER_HOST if it is empty.
+func newDockerClient(endpoint string) (*docker.Client, error) {
+	if endpoint == "" {
+		return docker.NewClientFromEnv()
+	}
+	return docker.NewClient(endpoint)
+}
+
+// removeContainer stops the container id and removes it with its volumes.
+func removeContainer(ctx context.Context, client *docker.Client, id string) error {
+	err := client.StopContainerWithContext(id, gcStopTimeout, ctx)
+	if _, ok := err.(*docker.ContainerNotRunning); err != nil && !ok {
+		return fmt.Errorf("failed to stop container: %v", err)
+	}
+
+	err = client.RemoveContainer(docker.RemoveContainerOptions{
+		ID:            id,
+		RemoveVolumes: true,
+		Force:         true,
+		Context:       ctx,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove container: %v", err)
+	}
+	return nil
+}
+
+// printGC writes what the GC removed, or would remove with dryRun, as a
+// table.
+func printGC(w io.Writer, results []gcResult, dryRun bool) {
+	if len(results) == 0 {
+		fmt.Fprintln(w, "nothing to clean up")
+		return
+	}
+
+	done := "removed"
+	if dryRun {
+		done = "would remove"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tID\tALLOC ID\tRESULT")
+	for _, r := range results {
+		allocID, result := r.AllocID, done
+		if allocID == "" {
+			allocID = "-"
+		}
+		if r.Err != nil {
+			result = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Kind, r.ID, allocID, result)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// apiVersionMismatchRe matches the go-plugin error for a plugin speaking
+	// a protocol version the host does not support.
+	apiVersionMismatchRe = regexp.MustCompile(`Incompatible API version with plugin\. Plugin version: (\d+), Client versions: \[([\d ]*)\]`)
+
+	// coreVersionMismatchRe matches the go-plugin error for a plugin built
+	// against an incompatible go-plugin release.
+	coreVersionMismatchRe = regexp.MustCompile(`Incompatible core API version with plugin\. Plugin version: (\d+), Core version: (\d+)`)
+)
+
+// handshakeError is a plugin handshake which failed because the plugin and
+// the host speak different protocol versions. Retrying will not help, the
+// plugin has to be rebuilt.
+type handshakeError struct {
+	kind          string
+	pluginVersion string
+	hostVersions  string
+	err           error
+}
+
+func (e *handshakeError) Error() string {
+	return fmt.Sprintf("plugin %s %s protocol version %s, host expects %s; rebuild the plugin against the nomad and go-plugin versions in go.mod",
+		pluginPath, e.kind, e.pluginVersion, e.hostVersions)
+}
+
+func (e *handshakeError) Unwrap() error {
+	return e.err
+}
+
+// wrapHandshakeError turns go-plugin version mismatch errors into a
+// *handshakeError with an actionable message. Other errors are returned as is.
+func wrapHandshakeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if m := apiVersionMismatchRe.FindStringSubmatch(err.Error()); m != nil {
+		return &handshakeError{
+			kind:          "driver",
+			pluginVersion: m[1],
+			hostVersions:  strings.Join(strings.Fields(m[2]), " or "),
+			err:           err,
+		}
+	}
+
+	if m := coreVersionMismatchRe.FindStringSubmatch(err.Error()); m != nil {
+		return &handshakeError{
+			kind:          "go-plugin core",
+			pluginVersion: m[1],
+			hostVersions:  m[2],
+			err:           err,
+		}
+	}
+
+	return err
+}
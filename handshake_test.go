@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+// testPluginProtocolEnv makes the test binary serve the mock driver as a
+// plugin speaking the protocol version in it instead of running the tests.
+const testPluginProtocolEnv = "HARNESS_TEST_PLUGIN_PROTOCOL"
+
+func TestMain(m *testing.M) {
+	if v := os.Getenv(testPluginProtocolEnv); v != "" {
+		serveTestPlugin(v)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// serveTestPlugin serves the mock driver with the handshake of a driver
+// plugin, except for its protocol version.
+func serveTestPlugin(version string) {
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid %s %q\n", testPluginProtocolEnv, version)
+		os.Exit(1)
+	}
+
+	handshake := base.Handshake
+	handshake.ProtocolVersion = uint(v)
+	logger := hclog.NewNullLogger()
+	d := mockdriver.New(context.Background(), logger)
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: handshake,
+		Plugins: plugin.PluginSet{
+			base.PluginTypeDriver: drivers.NewDriverPlugin(d, logger),
+			base.PluginTypeBase:   &base.PluginBase{Impl: d},
+		},
+		GRPCServer: plugin.DefaultGRPCServer,
+		Logger:     logger,
+	})
+}
+
+func TestHandshakeMismatch(t *testing.T) {
+	t.Setenv(testPluginProtocolEnv, "99")
+
+	oldPath, oldInProcess, oldRetries := pluginPath, pluginInProcess, connectRetries
+	pluginPath, pluginInProcess, connectRetries = os.Args[0], false, 0
+	t.Cleanup(func() {
+		pluginPath, pluginInProcess, connectRetries = oldPath, oldInProcess, oldRetries
+	})
+
+	logger := hclog.NewNullLogger()
+	dh, err := newHarness(logger, mockdriver.New(context.Background(), logger))
+	if err == nil {
+		dh.Close()
+		t.Fatal("connected to a plugin speaking another protocol version")
+	}
+
+	var he *handshakeError
+	if !errors.As(err, &he) {
+		t.Fatalf("expected a handshake error, got %v", err)
+	}
+	want := fmt.Sprintf("plugin %s driver protocol version 99, host expects %d; rebuild the plugin against the nomad and go-plugin versions in go.mod",
+		os.Args[0], base.Handshake.ProtocolVersion)
+	if err.Error() != want {
+		t.Errorf("error %q, expected %q", err, want)
+	}
+}
+
+func TestWrapHandshakeError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "api version",
+			err:  errors.New("Incompatible API version with plugin. Plugin version: 3, Client versions: [1 2]"),
+			want: fmt.Sprintf("plugin %s driver protocol version 3, host expects 1 or 2; rebuild the plugin against the nomad and go-plugin versions in go.mod", pluginPath),
+		},
+		{
+			name: "core version",
+			err:  errors.New("Incompatible core API version with plugin. Plugin version: 2, Core version: 1"),
+			want: fmt.Sprintf("plugin %s go-plugin core protocol version 2, host expects 1; rebuild the plugin against the nomad and go-plugin versions in go.mod", pluginPath),
+		},
+		{
+			name: "other",
+			err:  errors.New("plugin exited before we could connect"),
+			want: "plugin exited before we could connect",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := wrapHandshakeError(c.err).Error(); got != c.want {
+				t.Errorf("error %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// healthState is the health of a task as derived from an exec probe.
+type healthState string
+
+const (
+	healthHealthy   healthState = "healthy"
+	healthUnhealthy healthState = "unhealthy"
+	healthStarting  healthState = "starting"
+)
+
+// healthCodes maps probe exit codes to health states. It implements
+// flag.Value so the mapping can be given as "0=healthy,1=unhealthy,...".
+type healthCodes map[int]healthState
+
+// defaultHealthCodes follows the docker HEALTHCHECK conventions, with 2 used
+// for a task that is still starting.
+func defaultHealthCodes() healthCodes {
+	return healthCodes{
+		0: healthHealthy,
+		1: healthUnhealthy,
+		2: healthStarting,
+	}
+}
+
+func (c healthCodes) String() string {
+	codes := make([]int, 0, len(c))
+	for code := range c {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	parts := make([]string, 0, len(codes))
+	for _, code := range codes {
+		parts = append(parts, fmt.Sprintf("%d=%s", code, c[code]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set replaces the mapping with the one given in v.
+func (c healthCodes) Set(v string) error {
+	parsed := healthCodes{}
+	for _, part := range strings.Split(v, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid health code mapping %q, expected code=state", part)
+		}
+
+		code, err := strconv.Atoi(kv[0])
+		if err != nil {
+			return fmt.Errorf("invalid exit code %q: %v", kv[0], err)
+		}
+
+		switch state := healthState(kv[1]); state {
+		case healthHealthy, healthUnhealthy, healthStarting:
+			parsed[code] = state
+		default:
+			return fmt.Errorf("invalid health state %q", kv[1])
+		}
+	}
+
+	for code := range c {
+		delete(c, code)
+	}
+	for code, state := range parsed {
+		c[code] = state
+	}
+	return nil
+}
+
+// state returns the health state for an exit code. Codes without a mapping
+// are treated as unhealthy.
+func (c healthCodes) state(code int) healthState {
+	if state, ok := c[code]; ok {
+		return state
+	}
+	return healthUnhealthy
+}
+
+// probeHealth runs cmd inside the task and maps its exit code to a health
+// state.
+func probeHealth(d drivers.DriverPlugin, taskID string, cmd []string, timeout time.Duration, codes healthCodes) (healthState, error) {
+	res, err := d.ExecTask(taskID, cmd, timeout)
+	if err != nil {
+		return healthUnhealthy, fmt.Errorf("failed to exec health probe: %v", err)
+	}
+	if res.ExitResult == nil {
+		return healthUnhealthy, fmt.Errorf("health probe returned no exit result")
+	}
+
+	return codes.state(res.ExitResult.ExitCode), nil
+}
+
+// awaitHealthy probes the task every interval until it reports healthy and
+// returns the state it ended in. Unhealthy and starting tasks are probed
+// again, as docker does during the start period, until ctx is done.
+func awaitHealthy(ctx context.Context, d drivers.DriverPlugin, taskID string, cmd []string, timeout, interval time.Duration, codes healthCodes) (healthState, error) {
+	for {
+		state, err := probeHealth(d, taskID, cmd, timeout, codes)
+		if err == nil && state == healthHealthy {
+			return state, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return state, fmt.Errorf("task did not become healthy: %v", err)
+			}
+			return state, fmt.Errorf("task did not become healthy, last probe reported %s", state)
+		case <-time.After(interval):
+		}
+	}
+}
+
+// awaitRunning inspects the task every interval until it is running. A task
+// which exits first, or is still not running when ctx is done, is an error.
+func awaitRunning(ctx context.Context, d drivers.DriverPlugin, taskID string, interval time.Duration) error {
+	for {
+		status, err := d.InspectTask(taskID)
+		if err != nil {
+			return fmt.Errorf("failed to inspect task: %v", err)
+		}
+		switch status.State {
+		case drivers.TaskStateRunning:
+			return nil
+		case drivers.TaskStateExited:
+			return fmt.Errorf("task exited before it was running")
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("task still %s: %v", status.State, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+// probeDriver is a mock driver whose execs exit with codes one after the
+// other, repeating the last one.
+type probeDriver struct {
+	*mockdriver.Driver
+
+	mu    sync.Mutex
+	codes []int
+	execs int
+}
+
+func newProbeDriver(t *testing.T, codes ...int) *probeDriver {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &probeDriver{
+		Driver: mockdriver.NewWithConfig(ctx, hclog.NewNullLogger(), mockdriver.Config{}),
+		codes:  codes,
+	}
+}
+
+func (d *probeDriver) ExecTask(taskID string, cmd []string, timeout time.Duration) (*drivers.ExecTaskResult, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	i := d.execs
+	if i >= len(d.codes) {
+		i = len(d.codes) - 1
+	}
+	d.execs++
+	return &drivers.ExecTaskResult{ExitResult: &drivers.ExitResult{ExitCode: d.codes[i]}}, nil
+}
+
+func TestProbeHealth(t *testing.T) {
+	custom := defaultHealthCodes()
+	if err := custom.Set("0=healthy,3=starting"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name  string
+		codes healthCodes
+		exit  int
+		state healthState
+	}{
+		{"healthy", defaultHealthCodes(), 0, healthHealthy},
+		{"unhealthy", defaultHealthCodes(), 1, healthUnhealthy},
+		{"starting", defaultHealthCodes(), 2, healthStarting},
+		{"unmapped", defaultHealthCodes(), 7, healthUnhealthy},
+		{"custom starting", custom, 3, healthStarting},
+		{"custom replaced", custom, 2, healthUnhealthy},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := newProbeDriver(t, c.exit)
+			state, err := probeHealth(d, "task", []string{"probe"}, time.Second, c.codes)
+			if err != nil {
+				t.Fatalf("health probe failed: %v", err)
+			}
+			if state != c.state {
+				t.Errorf("exit code %d mapped to %s, expected %s", c.exit, state, c.state)
+			}
+		})
+	}
+}
+
+func TestAwaitHealthy(t *testing.T) {
+	d := newProbeDriver(t, 2, 1, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	state, err := awaitHealthy(ctx, d, "task", []string{"probe"}, time.Second, time.Millisecond, defaultHealthCodes())
+	if err != nil {
+		t.Fatalf("task did not pass the readiness gate: %v", err)
+	}
+	if state != healthHealthy {
+		t.Errorf("state %s, expected %s", state, healthHealthy)
+	}
+	if d.execs != 3 {
+		t.Errorf("probed %d times, expected 3", d.execs)
+	}
+}
+
+func TestAwaitHealthyTimeout(t *testing.T) {
+	d := newProbeDriver(t, 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	state, err := awaitHealthy(ctx, d, "task", []string{"probe"}, time.Second, time.Millisecond, defaultHealthCodes())
+	if err == nil {
+		t.Fatal("task which never became healthy passed the readiness gate")
+	}
+	if state != healthStarting {
+		t.Errorf("state %s, expected %s", state, healthStarting)
+	}
+}
+
+func TestAwaitRunning(t *testing.T) {
+	cases := []struct {
+		name   string
+		runFor time.Duration
+		err    string
+	}{
+		{
+			name:   "running",
+			runFor: time.Hour,
+		},
+		{
+			name: "exited",
+			err:  "task exited before it was running",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			d := mockdriver.NewWithConfig(ctx, hclog.NewNullLogger(), mockdriver.Config{RunFor: c.runFor})
+
+			task := &drivers.TaskConfig{ID: "task", Name: "task"}
+			if _, _, err := d.StartTask(task); err != nil {
+				t.Fatalf("failed to start task: %v", err)
+			}
+			// A task with no run time exits right after the start.
+			if c.runFor == 0 {
+				if _, err := waitExit(ctx, d, task.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := awaitRunning(ctx, d, task.ID, time.Millisecond)
+			switch {
+			case c.err == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case c.err != "" && (err == nil || err.Error() != c.err):
+				t.Errorf("error %v, expected %q", err, c.err)
+			}
+		})
+	}
+}
+
+// waitExit waits for the task to exit.
+func waitExit(ctx context.Context, d drivers.DriverPlugin, taskID string) (*drivers.ExitResult, error) {
+	ch, err := d.WaitTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+const (
+	// taskStateEventType tells task state events apart from the run report
+	// posted to the same webhook.
+	taskStateEventType = "task_state"
+
+	// stateDeliveryTimeout bounds the delivery of one task state event,
+	// retries included.
+	stateDeliveryTimeout = 5 * time.Second
+
+	// stateQueueSize is how many task state events may wait for delivery
+	// before further ones are dropped.
+	stateQueueSize = 64
+)
+
+// Task states reported by the lifecycle notifier. statePending is only ever
+// the previous state of a task's first event.
+const (
+	statePending = "pending"
+	stateStarted = "started"
+	stateExited  = "exited"
+	stateStopped = "stopped"
+	stateFailed  = "failed"
+)
+
+// taskStateEvent is the webhook body of a task state transition.
+type taskStateEvent struct {
+	Type      string    `json:"type"`
+	TaskID    string    `json:"task_id"`
+	AllocID   string    `json:"alloc_id"`
+	Name      string    `json:"name"`
+	Timestamp time.Time `json:"timestamp"`
+	PrevState string    `json:"prev_state"`
+	State     string    `json:"state"`
+	ExitCode  *int      `json:"exit_code,omitempty"`
+	Signal    int       `json:"signal,omitempty"`
+	OOMKilled bool      `json:"oom_killed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// trackedTask is a task the lifecycle notifier saw and the state it is in.
+type trackedTask struct {
+	task  *drivers.TaskConfig
+	state string
+}
+
+// lifecycleNotifier posts the state transitions of tasks to a webhook, in
+// order and best effort: a failed delivery is logged and the task carries on.
+// A nil notifier does nothing, so callers need not check for a webhook.
+type lifecycleNotifier struct {
+	hook   *webhook
+	logger hclog.Logger
+
+	mu    sync.Mutex
+	tasks map[string]*trackedTask
+
+	// closed is set under mu by Close, transitions after it are dropped
+	// instead of sent on the closed queue.
+	closed bool
+
+	queue     chan taskStateEvent
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// newLifecycleNotifier returns a notifier posting to hook, or nil if hook is
+// nil. Close must be called to deliver the events still queued.
+func newLifecycleNotifier(hook *webhook, logger hclog.Logger) *lifecycleNotifier {
+	if hook == nil {
+		return nil
+	}
+
+	n := &lifecycleNotifier{
+		hook:   hook,
+		logger: logger.Named("lifecycle"),
+		tasks:  make(map[string]*trackedTask),
+		queue:  make(chan taskStateEvent, stateQueueSize),
+		done:   make(chan struct{}),
+	}
+	go n.deliver()
+	return n
+}
+
+// deliver posts the queued events one after the other until Close.
+func (n *lifecycleNotifier) deliver() {
+	defer close(n.done)
+	for ev := range n.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), stateDeliveryTimeout)
+		if err := n.hook.post(ctx, ev); err != nil {
+			n.logger.Warn("failed to deliver task state event", "task_id", ev.TaskID, "state", ev.State, "error", err)
+		}
+		cancel()
+	}
+}
+
+// transition records that t is now in state and posts the change. res and
+// err are the exit result and failure of the task, if any. A task already in
+// state is not reported again.
+func (n *lifecycleNotifier) transition(t *drivers.TaskConfig, state string, res *drivers.ExitResult, err error) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		n.logger.Warn("notifier closed, dropping task state event", "task_id", t.ID, "state", state)
+		return
+	}
+	tt, ok := n.tasks[t.ID]
+	if !ok {
+		tt = &trackedTask{task: t, state: statePending}
+		n.tasks[t.ID] = tt
+	}
+	prev := tt.state
+	tt.state = state
+	if prev == state {
+		return
+	}
+
+	ev := taskStateEvent{
+		Type:      taskStateEventType,
+		TaskID:    t.ID,
+		AllocID:   t.AllocID,
+		Name:      t.Name,
+		Timestamp: time.Now(),
+		PrevState: prev,
+		State:     state,
+	}
+	if res != nil {
+		code := res.ExitCode
+		ev.ExitCode = &code
+		ev.Signal = res.Signal
+		ev.OOMKilled = res.OOMKilled
+	}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+
+	select {
+	case n.queue <- ev:
+	default:
+		n.logger.Warn("task state event queue full, dropping event", "task_id", t.ID, "state", state)
+	}
+}
+
+// shutdown reports how the tasks seen before ended according to results.
+// Results of other tasks are ignored.
+func (n *lifecycleNotifier) shutdown(results []shutdownResult) {
+	if n == nil {
+		return
+	}
+
+	for _, r := range results {
+		n.mu.Lock()
+		tt, ok := n.tasks[r.TaskID]
+		n.mu.Unlock()
+		if !ok {
+			continue
+		}
+
+		switch r.Outcome {
+		case outcomeExited:
+			n.transition(tt.task, stateExited, r.Exit, nil)
+		case outcomeStopped, outcomeTimedOut, outcomeKilled:
+			n.transition(tt.task, stateStopped, r.Exit, nil)
+		default:
+			n.transition(tt.task, stateFailed, r.Exit, r.Err)
+		}
+	}
+}
+
+// Close delivers the queued events and stops the notifier. Closing it again
+// does nothing, transitions recorded afterwards are dropped.
+func (n *lifecycleNotifier) Close() {
+	if n == nil {
+		return
+	}
+	n.closeOnce.Do(func() {
+		n.mu.Lock()
+		n.closed = true
+		close(n.queue)
+		n.mu.Unlock()
+		<-n.done
+	})
+}
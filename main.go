@@ -2,61 +2,222 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	hclog "github.com/hashicorp/go-hclog"
-	plugin "github.com/hashicorp/go-plugin"
-	"github.com/hashicorp/nomad/client/allocdir"
-	"github.com/hashicorp/nomad/client/config"
-	"github.com/hashicorp/nomad/client/logmon"
-	"github.com/hashicorp/nomad/client/taskenv"
 	"github.com/hashicorp/nomad/drivers/docker"
-	"github.com/hashicorp/nomad/helper/uuid"
-	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/drivers/exec"
+	"github.com/hashicorp/nomad/drivers/rawexec"
+	"github.com/hashicorp/nomad/helper"
 	"github.com/hashicorp/nomad/nomad/structs"
-	"github.com/hashicorp/nomad/plugins/base"
 	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
 )
 
-var (
-	// busyboxLongRunningCmd is a busybox command that runs indefinitely, and
-	// ideally responds to SIGINT/SIGTERM.  Sadly, busybox:1.29.3 /bin/sleep doesn't.
-	busyboxLongRunningCmd = []string{"nc", "-l", "-p", "3000", "127.0.0.1"}
-)
-
-var (
-	basicResources = &drivers.Resources{
-		NomadResources: &structs.AllocatedTaskResources{
-			Memory: structs.AllocatedMemoryResources{
-				MemoryMB: 256,
-			},
-			Cpu: structs.AllocatedCpuResources{
-				CpuShares: 250,
-			},
-		},
-		LinuxResources: &drivers.LinuxResources{
-			CPUShares:        512,
-			MemoryLimitBytes: 256 * 1024 * 1024,
-		},
-	}
-)
+// pluginPath is the driver plugin binary launched by the harness, set with
+// -plugin-path.
+var pluginPath = "./plugins/docker"
+
+// pluginAutoMTLS makes the plugin client and the plugin negotiate mutual TLS
+// for the gRPC connection, -no-mtls turns it off for local debugging.
+var pluginAutoMTLS = true
+
+// driverFactories create the driver served by the plugin binary, keyed by
+// the -driver name. The harness builds docker task configs, the command
+// drivers in commandDrivers only get their command and args.
+var driverFactories = map[string]func(context.Context, hclog.Logger) drivers.DriverPlugin{
+	"docker":   docker.NewDockerDriver,
+	"exec":     exec.NewExecDriver,
+	"raw_exec": rawexec.NewRawExecDriver,
+	"mock":     mockdriver.New,
+}
 
-type DriverHarness struct {
-	drivers.DriverPlugin
-	logger hclog.Logger
-	impl   drivers.DriverPlugin
+// inProcessDrivers run inside the harness process instead of being served by
+// the plugin binary, which only serves docker. The executor processes of exec
+// and raw_exec are the harness binary, run with the executor argument.
+var inProcessDrivers = map[string]bool{
+	"exec":     true,
+	"raw_exec": true,
+	"mock":     true,
 }
 
+// runningWait is how long the task checks wait for the task to run.
+const runningWait = 30 * time.Second
+
 func main() {
+	flag.StringVar(&pluginPath, "plugin-path", pluginPath, "driver plugin binary to launch")
+	flag.StringVar(&pluginReattachFile, "plugin-reattach", "", "connect to the running plugin in this reattach config file, if it exists, instead of launching -plugin-path")
+	flag.IntVar(&connectRetries, "connect-retries", connectRetries, "retry connecting to the plugin this many times, protocol version and checksum mismatches are not retried")
+	flag.DurationVar(&connectBackoff, "connect-backoff", connectBackoff, "wait this long before the first plugin connect retry, doubled for every further one")
+	flag.DurationVar(&grpcKeepalive, "grpc-keepalive", 0, "ping idle gRPC connections to the plugin and logmon this often, at least 5m; 0 disables pings")
+	flag.IntVar(&grpcMaxMsgMB, "grpc-max-msg-mb", 0, "largest gRPC message in MiB received from the plugin and logmon, 0 keeps go-plugin's default of 2 GiB")
+	flag.DurationVar(&pluginStartupTimeout, "startup-timeout", pluginStartupTimeout, "give up if the plugin does not handshake and dispense its driver within this long, 0 waits forever")
+	checksum := flag.String("plugin-checksum", "", "refuse to launch the plugin unless its SHA-256 is this hex value (default read from <plugin-path>.sha256 if it exists)")
+	noMTLS := flag.Bool("no-mtls", false, "talk to the plugin over plain gRPC instead of automatic mutual TLS")
+	logLevel := flag.String("log-level", "debug", "level of the harness and plugin logs written to /tmp/hashilogs")
+	driverName := flag.String("driver", "docker", "driver served by the plugin binary; exec or raw_exec to run a host command, or mock to run the in-memory mock driver, without docker")
+	loadImage := flag.String("load-image", "", "load the task image from this docker image tar instead of busybox.tar or a pull")
+	taskConfigPath := flag.String("task-config", "", "read the docker task config from this HCL or JSON file instead of the busybox default")
+	command := flag.String("command", "", "command to run in the task (default busybox nc listener)")
+	shell := flag.Bool("shell", false, "run -command through /bin/sh -c instead of exec form")
+	capture := flag.Bool("capture", false, "run the task to completion, print its output and exit")
+	noChroot := flag.Bool("no-chroot", false, "do not populate the task chroot (chroot isolation drivers only)")
+	follow := flag.Bool("follow", false, "stream the task stdout and stderr to the terminal while it runs")
+	logmonProcess := flag.Bool("logmon-process", false, "collect task logs in a logmon process which -recover can re-attach to")
+	withSidecar := flag.Bool("sidecar", false, "start a sidecar in the same alloc which tails the task logs")
+	capabilitiesMode := flag.Bool("capabilities", false, "print the driver capabilities and fingerprint and exit")
+	inspectMode := flag.Bool("inspect", false, "same as -capabilities, also logging what the driver reports")
+	auditFile := flag.String("audit-file", "", "append a JSON line for every task start, stop and exit to this file")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "export traces of the driver calls over OTLP/HTTP to this host:port")
+	dockerAuthPath := flag.String("docker-auth", "", "read registry credentials or a credentials helper for the image pull from this JSON file (default DOCKER_CONFIG)")
+	driverConfigPath := flag.String("driver-config", "", "read the docker plugin config, e.g. endpoint, gc and volumes, from this HCL or JSON file")
+	networkMode := flag.String("network-mode", "", "network mode of the task: host, bridge, or none for an alloc network namespace with only loopback (default the docker default)")
+	dockerHost := flag.String("docker-host", "", "docker endpoint for the driver, unix:// or tcp:// (default DOCKER_HOST or local socket)")
+	webhookURL := flag.String("webhook", "", "POST every task state change and the JSON run result when the run ends to this URL")
+	webhookSecret := flag.String("webhook-secret", "", "sign webhook bodies with HMAC-SHA256 using this secret")
+	serveAddr := flag.String("serve", "", "serve the task HTTP API on this address, e.g. :8080, instead of running a single task")
+	flag.StringVar(serveAddr, "http", "", "alias of -serve")
+	recoverFrom := flag.String("recover", "", "re-attach to the task saved in this task handle file instead of starting one")
+	preflightMode := flag.Bool("preflight", false, "check the environment, print a report and exit")
+	gcMode := flag.Bool("gc", false, "remove the alloc dirs and docker containers crashed harness runs left behind before starting")
+	gcDryRun := flag.Bool("gc-dry-run", false, "list what -gc would remove and exit")
+	stdoutFile := flag.String("stdout-file", "", "task stdout log file name inside the log dir (default <task>.stdout)")
+	stderrFile := flag.String("stderr-file", "", "task stderr log file name inside the log dir (default <task>.stderr)")
+	statsInterval := flag.Duration("stats-interval", time.Second, "print task CPU and memory usage at this interval, 0 dumps the task status instead")
+	execCmd := flag.String("exec", "", "exec this command in the running task with stdin, stdout and stderr attached")
+	healthCmd := flag.String("health-cmd", "", "command exec'd in the task to probe its health")
+	healthTimeout := flag.Duration("health-timeout", 5*time.Second, "timeout for a single health probe")
+	healthWait := flag.Duration("health-wait", time.Minute, "with -health-cmd, wait this long for the task to become healthy before it is reported started")
+	healthMap := defaultHealthCodes()
+	flag.Var(healthMap, "health-codes", "mapping of health probe exit codes to states")
+	var ports portFlags
+	flag.Var(&ports, "port-label", "reserve a task port as label=port, or label for a dynamic port (repeatable)")
+	var volumes volumeFlags
+	flag.Var(&volumes, "volume", "bind mount a host path into the container as host:container[:ro|rw] (repeatable)")
+	var portMaps portMapFlags
+	flag.Var(&portMaps, "port", "map a host port to a container port as host:container (repeatable)")
+	duration := flag.Duration("duration", 0, "stop the task and exit after this long (default run forever)")
+	stopGrace := flag.Duration("stop-grace", time.Second, "time a task is given to exit after StopTask")
+	taskID := flag.String("task-id", "", "use a fixed task ID (UUID) instead of a random one")
+	allocID := flag.String("alloc-id", "", "use a fixed alloc ID (UUID) instead of a random one")
+	memoryMB := flag.Int64("memory-mb", 256, "memory limit of the task in MB")
+	cpuShares := flag.Int64("cpu-shares", 250, "CPU shares of the task")
+	cpuLimit := flag.Float64("cpu-limit", 0, "hard limit the task to this percent of the host CPU (default no limit)")
+	pidsLimit := flag.Int64("pids-limit", 0, "maximum number of processes in the container (default unlimited)")
+	pullTimeout := flag.Duration("pull-timeout", 5*time.Minute, "docker image pull timeout")
+	pullRetries := flag.Int("pull-retries", 0, "times to retry StartTask after a transient image pull failure")
+	output := flag.String("output", "spew", "format of the task status printed each tick: spew, json or text")
+	count := flag.Int("count", 1, "run this many copies of the task concurrently, each in its own alloc dir")
+	benchTasks := flag.Int("bench", 0, "start and stop this many tasks one after the other against the one plugin and print their latency")
+	taskEnv := mapFlag{}
+	flag.Var(taskEnv, "env", "set an environment variable in the task as KEY=VALUE, taking precedence over the Nomad variables (repeatable)")
+	sysctls := mapFlag{}
+	flag.Var(sysctls, "sysctl", "set a namespaced sysctl in the container as key=value (repeatable)")
+	flag.Parse()
+	pluginAutoMTLS = !*noMTLS
+	var err error
+	pluginChecksum, err = loadPluginChecksum(*checksum, pluginPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pluginStartupTimeout < 0 {
+		log.Fatalf("-startup-timeout must not be negative, got %s", pluginStartupTimeout)
+	}
+	if connectRetries < 0 {
+		log.Fatalf("-connect-retries must not be negative, got %d", connectRetries)
+	}
+	if connectBackoff < 0 {
+		log.Fatalf("-connect-backoff must not be negative, got %s", connectBackoff)
+	}
+	if grpcKeepalive != 0 && grpcKeepalive < minGRPCKeepalive {
+		log.Fatalf("-grpc-keepalive must be 0 or at least %s, got %s", minGRPCKeepalive, grpcKeepalive)
+	}
+	if grpcMaxMsgMB < 0 || grpcMaxMsgMB > 2047 {
+		log.Fatalf("-grpc-max-msg-mb must be between 0 and 2047, got %d", grpcMaxMsgMB)
+	}
+
+	newDriver, ok := driverFactories[*driverName]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown driver %q\n", *driverName)
+		flag.Usage()
+		os.Exit(2)
+	}
+	pluginInProcess = inProcessDrivers[*driverName]
+	if commandDrivers[*driverName] {
+		if err := checkCommandDriverFlags(*driverName); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for name, id := range map[string]string{"task-id": *taskID, "alloc-id": *allocID} {
+		if id != "" && !helper.IsUUID(id) {
+			log.Fatalf("-%s %q is not a valid UUID", name, id)
+		}
+	}
+
+	if *pullTimeout <= 0 {
+		log.Fatalf("-pull-timeout must be positive, got %s", *pullTimeout)
+	}
+	if *pullRetries < 0 {
+		log.Fatalf("-pull-retries must not be negative, got %d", *pullRetries)
+	}
+
+	if !outputFormats[*output] {
+		log.Fatalf("-output must be spew, json or text, got %q", *output)
+	}
+
+	if *count < 1 {
+		log.Fatalf("-count must be at least 1, got %d", *count)
+	}
+	if *count > 1 {
+		for _, name := range []string{"task-id", "alloc-id", "capture", "sidecar", "exec"} {
+			if isFlagSet(name) {
+				log.Fatalf("-%s cannot be used with -count", name)
+			}
+		}
+	}
+	if *benchTasks < 0 {
+		log.Fatalf("-bench must not be negative, got %d", *benchTasks)
+	}
+	if *benchTasks > 0 && *count > 1 {
+		log.Fatal("-bench cannot be used with -count")
+	}
+	// Only the single task run sets up a network.
+	if *networkMode != "" {
+		for _, name := range []string{"count", "capture", "bench"} {
+			if isFlagSet(name) {
+				log.Fatalf("-network-mode cannot be used with -%s", name)
+			}
+		}
+	}
+
+	if *healthCmd != "" && *healthWait <= 0 {
+		log.Fatalf("-health-wait must be positive, got %s", *healthWait)
+	}
+
+	if *pidsLimit < 0 {
+		log.Fatalf("-pids-limit must not be negative, got %d", *pidsLimit)
+	}
+
+	// The process exits with the status of the task. Deferred first so every
+	// other deferred cleanup runs before os.Exit, which is why errors from
+	// here on set exitCode and return instead of calling log.Fatal.
+	var exitCode int
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
 	ctx := context.Background()
 
 	file, err := os.OpenFile("/tmp/hashilogs", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
@@ -64,270 +225,772 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// The plugin always logs at trace, its lines are relogged through this
+	// logger and so filtered by the same level.
+	level := hclog.LevelFromString(*logLevel)
+	if level == hclog.NoLevel {
+		log.Fatalf("-log-level must be trace, debug, info, warn or error, got %q", *logLevel)
+	}
+
 	logger := hclog.NewInterceptLogger(&hclog.LoggerOptions{
 		Name:       "agent",
-		Level:      hclog.LevelFromString("debug"),
+		Level:      level,
 		Output:     file,
 		JSONFormat: true,
 	})
 
-	d := docker.NewDockerDriver(ctx, logger)
-	pd := drivers.NewDriverPlugin(d, logger)
-
-	client := plugin.NewClient(&plugin.ClientConfig{
-		HandshakeConfig: base.Handshake,
-		Plugins: plugin.PluginSet{
-			base.PluginTypeDriver: pd,
-			base.PluginTypeBase:   &base.PluginBase{Impl: d},
-			"logmon":              logmon.NewPlugin(logmon.NewLogMon(logger.Named("logmon"))),
-		},
-
-		AllowedProtocols: []plugin.Protocol{
-			plugin.ProtocolGRPC,
-		},
-		Cmd: exec.Command("./plugins/docker"),
-	})
-	defer client.Kill()
+	shutdownTracing := setupTracing(*otlpEndpoint)
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Warn("failed to flush traces", "error", err)
+		}
+	}()
 
-	rpcClient, err := client.Client()
-	if err != nil {
-		log.Fatal(err)
+	var hook *webhook
+	if *webhookURL != "" {
+		hook, err = newWebhook(*webhookURL, *webhookSecret, logger)
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
 	}
+	// Every run records how its tasks ended in runResults, which is posted to
+	// the webhook once the notifier delivered the last task state change. A
+	// failed delivery is reported but does not change how the run ended.
+	var runResults []shutdownResult
+	defer func() {
+		if hook == nil || runResults == nil {
+			return
+		}
+		if err := hook.post(ctx, newRunResult(runResults)); err != nil {
+			logger.Error("failed to deliver run result", "error", err)
+			fmt.Fprintf(os.Stderr, "failed to deliver run result: %v\n", err)
+		}
+	}()
+	lifecycle := newLifecycleNotifier(hook, logger)
+	defer lifecycle.Close()
+
+	d := newDriver(ctx, logger)
 
-	raw, err := rpcClient.Dispense(base.PluginTypeDriver)
+	auth, err := loadDockerAuth(*dockerAuthPath)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(err)
+		exitCode = 1
+		return
+	}
+	fileConfig, err := loadDriverConfig(d, *driverConfigPath)
+	if err != nil {
+		log.Print(err)
+		exitCode = 1
+		return
+	}
+	// Registry auth from the driver config file wins over DOCKER_CONFIG,
+	// but not over -docker-auth.
+	driverAuth := auth.driver
+	if fileConfig != nil && fileConfig.Auth != (docker.AuthConfig{}) && *dockerAuthPath == "" {
+		driverAuth = docker.AuthConfig{}
+	}
+	driverConfig, err := newDriverConfig(fileConfig, *dockerHost, len(volumes) > 0, driverAuth)
+	if err != nil {
+		log.Print(err)
+		exitCode = 1
+		return
+	}
+	if commandDrivers[*driverName] {
+		driverConfig, err = commandDriverConfig(d, *driverName)
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
 	}
 
-	dClient := raw.(drivers.DriverPlugin)
+	if *gcMode || *gcDryRun {
+		endpoint := *dockerHost
+		if endpoint == "" && fileConfig != nil {
+			endpoint = fileConfig.Endpoint
+		}
+		results, err := runGC(ctx, logger, *driverName == "docker", endpoint, *gcDryRun)
+		printGC(os.Stdout, results, *gcDryRun)
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		if *gcDryRun {
+			return
+		}
+	}
 
-	dh := DriverHarness{
-		logger:       logger,
-		DriverPlugin: dClient,
-		impl:         d,
+	if *preflightMode {
+		results := runPreflight(ctx, logger, d, driverConfig, *loadImage)
+		printPreflight(os.Stdout, results)
+		if preflightFailed(results) {
+			exitCode = 1
+		}
+		return
 	}
 
-	var data []byte
-	baseConfig := &base.Config{PluginConfig: data}
-	err = dClient.SetConfig(baseConfig)
-	if err != nil {
-		log.Fatal(err)
+	// Unless given, the harness defaults are kept.
+	var harnessOpts []harness.Option
+	if isFlagSet("memory-mb") || isFlagSet("cpu-shares") || isFlagSet("cpu-limit") {
+		resources, err := harness.NewResources(*memoryMB, *cpuShares, *cpuLimit)
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		harnessOpts = append(harnessOpts, harness.WithResources(resources))
+	}
+	// docker only applies the CPU limit as a hard limit.
+	if *cpuLimit > 0 {
+		harnessOpts = append(harnessOpts, harness.WithTaskConfig(func(command []string) docker.TaskConfig {
+			cfg := harness.DefaultTaskConfig(command)
+			cfg.CPUHardLimit = true
+			return cfg
+		}))
 	}
 
-	// try
-	taskCfg := newTaskConfig("", busyboxLongRunningCmd)
-	task := &drivers.TaskConfig{
-		ID:        uuid.Generate(),
-		Name:      "nc-demo",
-		AllocID:   uuid.Generate(),
-		Resources: basicResources,
+	if commandDrivers[*driverName] {
+		harnessOpts = append(harnessOpts, harness.WithCommandTasks())
+	}
+	if *logmonProcess {
+		harnessOpts = append(harnessOpts, harness.WithLogmonProcess())
+	}
+	if *loadImage != "" {
+		if err := checkReadable(*loadImage); err != nil {
+			log.Printf("-load-image: %v", err)
+			exitCode = 1
+			return
+		}
+		harnessOpts = append(harnessOpts, harness.WithLoadImage(*loadImage))
+	}
+	if *auditFile != "" {
+		audit, err := harness.OpenAuditLog(*auditFile)
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		// Closed after the harness, so the stops of its last tasks are in.
+		defer func() {
+			if err := audit.Close(); err != nil {
+				logger.Error("failed to close audit file", "error", err)
+			}
+		}()
+		harnessOpts = append(harnessOpts, harness.WithAuditLog(audit))
 	}
 
-	cleanup, err := dh.MkAllocDir(task, true)
+	spawnStart := time.Now()
+	dh, err := newHarness(logger, d, harnessOpts...)
 	if err != nil {
-		log.Fatal(err)
+		log.Print(withPluginStderr(err))
+		exitCode = 1
+		return
 	}
-	defer cleanup()
+	spawn := time.Since(spawnStart)
+	defer dh.Close()
 
-	task.EncodeConcreteDriverConfig(&taskCfg)
+	if err := dh.SetConfig(driverConfig); err != nil {
+		log.Print(withPluginStderr(fmt.Errorf("failed to configure driver: %v", err)))
+		exitCode = 1
+		return
+	}
 
-	_, _, err = dClient.StartTask(task)
-	if err != nil {
-		log.Fatal(err)
+	if *dockerHost != "" {
+		fpCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		fp, err := firstFingerprint(fpCtx, dh.DriverPlugin)
+		cancel()
+		switch {
+		case err != nil:
+			logger.Error("failed to fingerprint docker", "endpoint", *dockerHost, "error", err)
+			fmt.Fprintf(os.Stderr, "docker at %s: %v\n", *dockerHost, err)
+		case fp.Health != drivers.HealthStateHealthy:
+			fmt.Fprintf(os.Stderr, "docker at %s is %s: %s\n", *dockerHost, fp.Health, fp.HealthDescription)
+		}
 	}
 
-	defer func() {
-		err := dClient.StopTask(task.ID, time.Second, "SIGINT")
+	if *capabilitiesMode || *inspectMode {
+		caps, err := dh.Capabilities()
 		if err != nil {
-			log.Fatal(err)
+			log.Print(err)
+			exitCode = 1
+			return
 		}
-		err = dClient.DestroyTask(task.ID, true)
+
+		fpCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		fp, err := firstFingerprint(fpCtx, dh.DriverPlugin)
+		cancel()
 		if err != nil {
-			log.Fatal(err)
+			logger.Error("failed to fingerprint driver", "error", err)
 		}
 
-		time.Sleep(time.Second * 5)
-	}()
+		if *inspectMode {
+			logCapabilities(logger, caps, fp)
+		}
 
-	time.Sleep(time.Second * 5)
+		printCapabilities(os.Stdout, caps, fp)
+		return
+	}
 
-	// Task rss usage
-	//tt, err := dClient.TaskStats(ctx, task.ID, time.Second)
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
-	//
-	//for elem := range tt {
-	//	spew.Dump(elem)
-	//}
+	if *noChroot {
+		if err := dh.SkipChroot(true); err != nil {
+			log.Printf("-no-chroot: %v", err)
+			exitCode = 1
+			return
+		}
+	}
 
-	// plugin info
-	//ff, err := dClient.Fingerprint(ctx)
-	//if err != nil {
-	//	log.Fatal(err)
-	//}
-	//
-	//for elem := range ff {
-	//	spew.Dump(elem)
-	//}
+	if *serveAddr != "" {
+		serveCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		results, err := newServer(logger, dh, lifecycle, *stopGrace, *statsInterval).Serve(serveCtx, *serveAddr)
+		stop()
+		printShutdown(os.Stdout, results)
+		runResults = results
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		return
+	}
 
-	for {
-		status, err := dClient.InspectTask(task.ID)
+	if *recoverFrom != "" {
+		recoverCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		// -duration counts from the re-attach, as from the start of a task.
+		if *duration > 0 {
+			var cancel context.CancelFunc
+			recoverCtx, cancel = context.WithTimeout(recoverCtx, *duration)
+			defer cancel()
+		}
+		results, err := runRecovered(recoverCtx, logger, dh, *recoverFrom, *stopGrace)
+		stop()
 		if err != nil {
-			log.Fatal(err)
+			fmt.Fprintln(os.Stderr, err)
+			runResults = []shutdownResult{{Outcome: outcomeFailed, Err: err}}
+			exitCode = 1
+			return
 		}
+		printShutdown(os.Stdout, results)
+		runResults = results
+		exitCode = taskExitCode(results, results[0].TaskID)
+		return
+	}
 
-		spew.Dump(status)
-		time.Sleep(time.Second * 2)
+	resources := dh.Resources()
+	portMap, err := portMaps.reserve(&ports)
+	if err != nil {
+		log.Print(err)
+		exitCode = 1
+		return
+	}
+	if len(ports) > 0 {
+		nw, err := ports.networkResource()
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		nomadResources := *resources.NomadResources
+		nomadResources.Networks = structs.Networks{nw}
+		resources = &drivers.Resources{
+			NomadResources: &nomadResources,
+			LinuxResources: resources.LinuxResources,
+		}
 	}
 
-	//	err = dClient.StopTask(task.ID, time.Second, "SIGINT")
-	//	if err != nil {
-	//		log.Fatal(err)
-	//	}
+	// The busybox default listener is not on the host.
+	if commandDrivers[*driverName] && *command == "" && *taskConfigPath == "" {
+		log.Printf("-driver %s needs a -command", *driverName)
+		exitCode = 1
+		return
+	}
+	cmd, err := taskCommand(*command, *shell)
+	if err != nil {
+		log.Print(err)
+		exitCode = 1
+		return
+	}
 
-}
+	spec := harness.DefaultTaskSpec()
+	spec.ID, spec.AllocID = *taskID, *allocID
+	spec.Command, spec.Args = cmd[0], cmd[1:]
+	spec.Resources = resources
+	spec.PortMap = portMap
+	// Seeded before MkAllocDir, which only adds the Nomad variables that are
+	// not set yet.
+	spec.Env = taskEnv
+	if len(volumes) > 0 {
+		if err := checkMountSupport(dh.DriverPlugin); err != nil {
+			log.Printf("-volume: %v", err)
+			exitCode = 1
+			return
+		}
+		spec.Mounts = volumes.dockerMounts()
+	}
 
-func newTaskConfig(variant string, command []string) docker.TaskConfig {
-	// busyboxImageID is the ID stored in busybox.tar
-	busyboxImageID := "busybox:1.29.3"
+	// A task config file replaces the harness task config the spec is
+	// applied to, its command is kept unless -command is given.
+	var fileCfg *docker.TaskConfig
+	if *taskConfigPath != "" {
+		cfg, err := loadTaskConfig(dh.DriverPlugin, *taskConfigPath)
+		if err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		if *command == "" {
+			spec.Command, spec.Args = cfg.Command, cfg.Args
+		}
+		if *loadImage != "" {
+			cfg.LoadImage = filepath.Base(*loadImage)
+		}
+		fileCfg = &cfg
+	}
 
-	image := busyboxImageID
-	loadImage := "busybox.tar"
-	if variant != "" {
-		image = fmt.Sprintf("%s-%s", busyboxImageID, variant)
-		loadImage = fmt.Sprintf("busybox_%s.tar", variant)
+	task, taskCfg, err := dh.NewTask(spec)
+	if err != nil {
+		log.Print(err)
+		exitCode = 1
+		return
+	}
+	if fileCfg != nil {
+		spec.ApplyTo(fileCfg)
+		taskCfg = *fileCfg
+	}
+	if auth.task != (docker.DockerAuth{}) {
+		taskCfg.Auth = auth.task
+	}
+	if len(sysctls) > 0 {
+		if err := validateSysctls(sysctls); err != nil {
+			log.Print(err)
+			exitCode = 1
+			return
+		}
+		taskCfg.Sysctl = map[string]string(sysctls)
+	}
+	// A pull timeout from the task config file wins over the flag default.
+	if taskCfg.ImagePullTimeout == "" || *taskConfigPath == "" || isFlagSet("pull-timeout") {
+		taskCfg.ImagePullTimeout = pullTimeout.String()
 	}
 
-	return docker.TaskConfig{
-		Image:            image,
-		ImagePullTimeout: "5m",
-		LoadImage:        loadImage,
-		Command:          command[0],
-		Args:             command[1:],
+	applyPidsLimit(&taskCfg, *pidsLimit, *taskConfigPath != "", isFlagSet("pids-limit"))
+	// A task config file may not set the hard limit -cpu-limit needs.
+	if *cpuLimit > 0 {
+		taskCfg.CPUHardLimit = true
 	}
-}
 
-// MkAllocDir creates a temporary directory and allocdir structure.
-// If enableLogs is set to true a logmon instance will be started to write logs
-// to the LogDir of the task
-// A cleanup func is returned and should be deferred so as to not leak dirs
-// between tests.
-func (h *DriverHarness) MkAllocDir(t *drivers.TaskConfig, enableLogs bool) (func(), error) {
-	dir, err := ioutil.TempDir("", "nomad_driver_harness-")
-	if err != nil {
-		return nil, err
+	// Encoded before the alloc dir is built so its meta.json records the image
+	// and command.
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	// Ctrl-C or SIGTERM cancels the run instead of killing the process, so the
+	// task is stopped and the deferred cleanup of the alloc dir and plugin
+	// runs.
+	sigCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	if *count > 1 {
+		copiesCtx, cancel := context.WithCancel(sigCtx)
+		if *duration > 0 {
+			copiesCtx, cancel = context.WithTimeout(sigCtx, *duration)
+		}
+		results := runCopies(copiesCtx, logger, dh, lifecycle, task, taskCfg, *count, *stdoutFile, *stderrFile, *stopGrace, *pullRetries)
+		cancel()
+		printShutdown(os.Stdout, results)
+		runResults = results
+		exitCode = copiesExitCode(results)
+		return
 	}
-	t.AllocDir = dir
 
-	allocDir := allocdir.NewAllocDir(h.logger, dir)
-	err = allocDir.Build()
-	if err != nil {
-		return nil, err
+	if *benchTasks > 0 {
+		results, err := runBench(sigCtx, os.Stdout, dh, task, taskCfg, *benchTasks, spawn, *stopGrace)
+		runResults = results
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+		}
+		return
 	}
 
-	taskDir := allocDir.NewTaskDir(t.Name)
+	if *capture {
+		stdout, stderr, res, err := dh.RunAndCapture(sigCtx, task)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			runResults = []shutdownResult{{TaskID: task.ID, Name: task.Name, Outcome: outcomeFailed, Err: err}}
+			exitCode = 1
+			return
+		}
+		fmt.Print(stdout)
+		fmt.Fprint(os.Stderr, stderr)
+		fmt.Printf("exit code: %d\n", res.ExitCode)
+		runResults = []shutdownResult{{TaskID: task.ID, Name: task.Name, Outcome: outcomeExited, Exit: res}}
+		exitCode = processExitCode(res)
+		return
+	}
 
-	caps, err := h.Capabilities()
+	ad, err := dh.BuildAllocDir(task, true, *stdoutFile, *stderrFile)
 	if err != nil {
-		return nil, err
+		log.Print(err)
+		exitCode = 1
+		return
 	}
+	defer func() {
+		ad.StopLogs()
+		ad.Destroy()
+	}()
 
-	fsi := caps.FSIsolation
-	err = taskDir.Build(fsi == drivers.FSIsolationChroot, config.DefaultChrootEnv)
-	if err != nil {
-		return nil, err
+	// Let the command refer to its ports, e.g. $NOMAD_PORT_http
+	taskCfg.Args = expandTaskEnv(taskCfg.Args, task.Env)
+	for _, p := range ports {
+		fmt.Printf("port %s: %s\n", p.Label, task.Env["NOMAD_ADDR_"+p.Label])
 	}
 
-	task := &structs.Task{
-		Name: t.Name,
-		Env:  t.Env,
+	// The docker network mode the task ends up with, none joins the alloc
+	// network namespace SetupNetwork created. The command drivers have no
+	// driver network, their tasks share the network of the host.
+	netMode := taskCfg.NetworkMode
+	if commandDrivers[*driverName] {
+		netMode = "host"
 	}
+	if *networkMode != "" {
+		destroyNetwork, err := dh.SetupNetwork(task, *networkMode)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exitCode = 1
+			return
+		}
+		defer destroyNetwork()
+
+		netMode = *networkMode
+		taskCfg.NetworkMode = *networkMode
+		if *networkMode == harness.NetworkModeNone {
+			taskCfg.NetworkMode = ""
+		}
+	}
+
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	// Subscribed before the start so image pull events are seen too.
+	eventsCtx, stopEvents := context.WithCancel(ctx)
+	defer stopEvents()
+	go dh.WatchEvents(eventsCtx, task.AllocID)
 
-	// Create the mock allocation
-	alloc := mock.Alloc()
-	if t.Resources != nil {
-		alloc.AllocatedResources.Tasks[task.Name] = t.Resources.NomadResources
+	handle, dnet, err := startTaskWithPullRetries(dh, logger, task, *pullRetries)
+	if err != nil {
+		switch {
+		case len(sysctls) > 0:
+			fmt.Fprintf(os.Stderr, "failed to start task with sysctls %s: %v\n", sysctls, err)
+		case taskCfg.PidsLimit > 0:
+			fmt.Fprintf(os.Stderr, "failed to start task with pids limit %d: %v\n", taskCfg.PidsLimit, err)
+		default:
+			fmt.Fprintln(os.Stderr, err)
+		}
+		logger.Error("failed to start task", "error", err, "plugin_stderr", pluginStderr.String())
+		lifecycle.transition(task, stateFailed, nil, err)
+		runResults = []shutdownResult{{TaskID: task.ID, Name: task.Name, Outcome: outcomeStartFailed, Err: err}}
+		exitCode = 1
+		return
 	}
 
-	taskBuilder := taskenv.NewBuilder(mock.Node(), alloc, task, "global")
-	SetEnvvars(taskBuilder, fsi, taskDir, config.DefaultConfig())
+	// Readiness gate: with a health probe the task only counts as started
+	// once it reports healthy.
+	if *healthCmd != "" {
+		gateCtx, cancel := context.WithTimeout(sigCtx, *healthWait)
+		state, err := awaitHealthy(gateCtx, dh.DriverPlugin, task.ID, strings.Fields(*healthCmd), *healthTimeout, time.Second, healthMap)
+		cancel()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			logger.Error("task did not become healthy", "state", state, "error", err)
+			lifecycle.transition(task, stateFailed, nil, err)
+			results := Shutdown(ctx, dh, []*drivers.TaskConfig{task}, *stopGrace, "SIGINT")
+			printShutdown(os.Stdout, results)
+			runResults = results
+			exitCode = 1
+			return
+		}
+		fmt.Printf("health: %s\n", state)
+	}
+	lifecycle.transition(task, stateStarted, nil, nil)
 
-	taskEnv := taskBuilder.Build()
-	if t.Env == nil {
-		t.Env = taskEnv.Map()
+	if path, err := harness.SaveTaskHandle(handle); err != nil {
+		logger.Error("failed to save task handle", "error", err)
 	} else {
-		for k, v := range taskEnv.Map() {
-			if _, ok := t.Env[k]; !ok {
-				t.Env[k] = v
+		fmt.Printf("task handle saved to %s\n", path)
+	}
+
+	dnet = awaitNetwork(dh.DriverPlugin, task.ID, netMode, dnet)
+	printNetwork(os.Stdout, netMode, task, dnet)
+
+	// The sidecar starts after the main task and is stopped before it.
+	tasks := []*drivers.TaskConfig{task}
+	if *withSidecar {
+		mainStdout, mainStderr := harness.LogFileNames(task, *stdoutFile, *stderrFile)
+		sidecar, sidecarCfg := newSidecarTask(dh, task, mainStdout, mainStderr)
+
+		sidecarCleanup, err := dh.MkSidecarDir(task, sidecar, true)
+		if err == nil {
+			defer sidecarCleanup()
+
+			dh.EncodeTaskConfig(sidecar, sidecarCfg)
+			if _, _, err = dh.StartTask(sidecar); err != nil {
+				err = fmt.Errorf("failed to start sidecar: %v", err)
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			lifecycle.transition(sidecar, stateFailed, nil, err)
+			results := Shutdown(ctx, dh, tasks, *stopGrace, "SIGINT")
+			lifecycle.shutdown(results)
+			printShutdown(os.Stdout, results)
+			runResults = append(results, shutdownResult{TaskID: sidecar.ID, Name: sidecar.Name, Outcome: outcomeStartFailed, Err: err})
+			exitCode = 1
+			return
+		}
+		lifecycle.transition(sidecar, stateStarted, nil, nil)
+		tasks = []*drivers.TaskConfig{sidecar, task}
+	}
+
+	runCtx, cancelRun := context.WithCancel(sigCtx)
+	if *duration > 0 {
+		runCtx, cancelRun = context.WithTimeout(sigCtx, *duration)
+	}
+	defer cancelRun()
+
+	// Not tied to runCtx, the task may still exit after a forwarded signal.
+	waitCtx, stopWait := context.WithCancel(ctx)
+	defer stopWait()
+
+	// Stopped after the shutdown report, before the alloc dir is removed.
+	if *follow {
+		tailCtx, stopTail := context.WithCancel(ctx)
+		tailDone := make(chan struct{})
+		go func() {
+			defer close(tailDone)
+			if err := ad.TailLogs(tailCtx, os.Stdout); err != nil {
+				logger.Error("failed to tail task logs", "error", err)
+			}
+		}()
+		defer func() {
+			stopTail()
+			<-tailDone
+		}()
+	}
+
+	// waited is set once the result of the WaitTask goroutine is received.
+	waited := false
+	exitCh := make(chan *drivers.ExitResult, 1)
+	go func() {
+		res, err := dh.WaitTask(waitCtx, task.ID)
+		switch {
+		case err == nil:
+			lifecycle.transition(task, stateExited, res, nil)
+		case waitCtx.Err() == nil:
+			logger.Error("failed to wait on task", "error", err)
+		}
+		exitCh <- res
+	}()
+
+	// The checks exec into the task, so they wait for it to run. The wait
+	// has its own deadline rather than runCtx, which a short -duration would
+	// end before the checks ran.
+	checks := len(ports) > 0 || len(sysctls) > 0 || len(volumes) > 0 || taskCfg.PidsLimit > 0 || *execCmd != ""
+	if checks {
+		runningCtx, cancel := context.WithTimeout(sigCtx, runningWait)
+		err = awaitRunning(runningCtx, dh.DriverPlugin, task.ID, 250*time.Millisecond)
+		cancel()
+		if err != nil {
+			logger.Error("skipping task checks", "error", err)
+			fmt.Fprintf(os.Stderr, "skipping task checks: %v\n", err)
+			checks = false
+		}
+	}
+	// A failed check fails the run even if the task exits with 0.
+	checksFailed := false
+	if checks {
+		if len(sysctls) > 0 {
+			if err := verifySysctls(dh.DriverPlugin, task.ID, sysctls); err != nil {
+				logger.Error("sysctl verification failed", "error", err)
+				fmt.Fprintf(os.Stderr, "sysctls: %v\n", err)
+				checksFailed = true
+			} else {
+				fmt.Printf("sysctls applied: %s\n", sysctls)
+			}
+		}
+
+		if len(ports) > 0 {
+			if err := verifyPortEnv(dh.DriverPlugin, task.ID, ports, task.Env); err != nil {
+				logger.Error("port verification failed", "error", err)
+				fmt.Fprintf(os.Stderr, "ports: %v\n", err)
+				checksFailed = true
+			} else {
+				fmt.Printf("ports visible in task: %s\n", &ports)
+			}
+		}
+
+		if len(volumes) > 0 {
+			if err := verifyReadOnly(dh.DriverPlugin, task.ID, volumes); err != nil {
+				logger.Error("volume verification failed", "error", err)
+				fmt.Fprintf(os.Stderr, "volumes: %v\n", err)
+				checksFailed = true
+			} else {
+				fmt.Printf("volumes mounted: %s\n", &volumes)
+			}
+		}
+
+		if taskCfg.PidsLimit > 0 {
+			if err := verifyPidsLimit(dh.DriverPlugin, task.ID, taskCfg.PidsLimit); err != nil {
+				logger.Error("pids limit verification failed", "error", err)
+				fmt.Fprintf(os.Stderr, "pids limit: %v\n", err)
+				checksFailed = true
+			} else {
+				fmt.Printf("pids limit %d enforced\n", taskCfg.PidsLimit)
+			}
+		}
+
+		if *execCmd != "" {
+			res, err := dh.ExecStreaming(sigCtx, task.ID, strings.Fields(*execCmd), os.Stdin, os.Stdout, os.Stderr)
+			if err != nil {
+				logger.Error("exec failed", "error", err)
+				fmt.Fprintf(os.Stderr, "exec: %v\n", err)
+				checksFailed = true
+			} else {
+				fmt.Printf("exec exited with code %d\n", res.ExitCode)
 			}
 		}
 	}
 
-	//logmon
-	if enableLogs {
-		lm := logmon.NewLogMon(h.logger.Named("logmon"))
-		if runtime.GOOS == "windows" {
-			id := uuid.Generate()[:8]
-			t.StdoutPath = fmt.Sprintf("//./pipe/%s-%s.stdout", t.Name, id)
-			t.StderrPath = fmt.Sprintf("//./pipe/%s-%s.stderr", t.Name, id)
+	if *statsInterval > 0 {
+		statsCh, err := dh.StreamStats(runCtx, task.ID, *statsInterval)
+		if err != nil {
+			logger.Warn("task stats unavailable", "error", err)
+			fmt.Fprintf(os.Stderr, "task stats unavailable: %v\n", err)
 		} else {
-			t.StdoutPath = filepath.Join(taskDir.LogDir, fmt.Sprintf(".%s.stdout.fifo", t.Name))
-			t.StderrPath = filepath.Join(taskDir.LogDir, fmt.Sprintf(".%s.stderr.fifo", t.Name))
-		}
-		err = lm.Start(&logmon.LogConfig{
-			LogDir:        taskDir.LogDir,
-			StdoutLogFile: fmt.Sprintf("%s.stdout", t.Name),
-			StderrLogFile: fmt.Sprintf("%s.stderr", t.Name),
-			StdoutFifo:    t.StdoutPath,
-			StderrFifo:    t.StderrPath,
-			MaxFiles:      10,
-			MaxFileSizeMB: 10,
-		})
+			go func() {
+				for u := range statsCh {
+					printStats(os.Stdout, u)
+				}
+			}()
+		}
+	}
+
+	// plugin info
+	//ff, err := dh.Fingerprint(ctx)
+	//if err != nil {
+	//	log.Fatal(err)
+	//}
+	//
+	//for elem := range ff {
+	//	spew.Dump(elem)
+	//}
+
+monitor:
+	for {
+		status, err := dh.InspectTask(task.ID)
 		if err != nil {
-			return nil, err
+			logger.Error("failed to inspect task", "error", err)
+			fmt.Fprintf(os.Stderr, "failed to inspect task: %v\n", err)
+			break monitor
+		}
+
+		// The status is printed every tick when stats are off or -output
+		// is given.
+		if *statsInterval <= 0 || isFlagSet("output") {
+			if err := printState(os.Stdout, *output, status); err != nil {
+				logger.Error("failed to print task status", "error", err)
+			}
+		}
+
+		if *healthCmd != "" {
+			state, err := probeHealth(dh.DriverPlugin, task.ID, strings.Fields(*healthCmd), *healthTimeout, healthMap)
+			if err != nil {
+				logger.Error("health probe failed", "error", err)
+			}
+			fmt.Printf("health: %s\n", state)
+		}
+
+		select {
+		case res := <-exitCh:
+			waited = true
+			if res != nil {
+				fmt.Printf("task exited with code %d\n", res.ExitCode)
+			}
+			break monitor
+		case <-runCtx.Done():
+			if sigCtx.Err() == nil {
+				break monitor
+			}
+			// Interrupted, Ctrl-C is forwarded to the task and it is given
+			// the stop grace period to act on it before Shutdown stops it.
+			if err := dh.SignalTask(task.ID, "SIGINT"); err != nil {
+				logger.Error("failed to forward signal", "signal", "SIGINT", "error", err)
+			} else {
+				fmt.Println("forwarded SIGINT to task")
+			}
+			select {
+			case res := <-exitCh:
+				waited = true
+				if res != nil {
+					fmt.Printf("task exited with code %d\n", res.ExitCode)
+				}
+			case <-time.After(*stopGrace):
+			}
+			break monitor
+		case <-time.After(time.Second * 2):
 		}
+	}
 
-		return func() {
-			lm.Stop()
-			allocDir.Destroy()
-		}, nil
+	// The wait is ended before Shutdown, which reports the stop itself and
+	// would otherwise be followed by a second, exited, transition.
+	if !waited {
+		stopWait()
+		<-exitCh
 	}
 
-	return func() {
-		allocDir.Destroy()
-	}, nil
+	var results []shutdownResult
+	for _, t := range tasks {
+		results = append(results, Shutdown(ctx, dh, []*drivers.TaskConfig{t}, *stopGrace, "SIGINT")...)
+	}
+	lifecycle.shutdown(results)
+	printShutdown(os.Stdout, results)
+	runResults = results
+	exitCode = taskExitCode(results, task.ID)
+	if checksFailed && exitCode == 0 {
+		exitCode = 1
+	}
 }
 
-// SetEnvvars sets path and host env vars depending on the FS isolation used.
-func SetEnvvars(envBuilder *taskenv.Builder, fsi drivers.FSIsolation, taskDir *allocdir.TaskDir, conf *config.Config) {
-
-	envBuilder.SetClientTaskRoot(taskDir.Dir)
-	envBuilder.SetClientSharedAllocDir(taskDir.SharedAllocDir)
-	envBuilder.SetClientTaskLocalDir(taskDir.LocalDir)
-	envBuilder.SetClientTaskSecretsDir(taskDir.SecretsDir)
-
-	// Set driver-specific environment variables
-	switch fsi {
-	case drivers.FSIsolationNone:
-		// Use host paths
-		envBuilder.SetAllocDir(taskDir.SharedAllocDir)
-		envBuilder.SetTaskLocalDir(taskDir.LocalDir)
-		envBuilder.SetSecretsDir(taskDir.SecretsDir)
-	default:
-		// filesystem isolation; use container paths
-		envBuilder.SetAllocDir(allocdir.SharedAllocContainerPath)
-		envBuilder.SetTaskLocalDir(allocdir.TaskLocalContainerPath)
-		envBuilder.SetSecretsDir(allocdir.TaskSecretsContainerPath)
-	}
-
-	// Set the host environment variables for non-image based drivers
-	if fsi != drivers.FSIsolationImage {
-		// COMPAT(1.0) using inclusive language, blacklist is kept for backward compatibility.
-		filter := strings.Split(conf.ReadAlternativeDefault(
-			[]string{"env.denylist", "env.blacklist"},
-			config.DefaultEnvDenylist,
-		), ",")
-		envBuilder.SetHostEnvvars(filter)
+// taskExitCode returns the process exit code for taskID from the shutdown
+// results, see processExitCode, or 1 if it has no exit result.
+func taskExitCode(results []shutdownResult, taskID string) int {
+	for _, r := range results {
+		if r.TaskID == taskID && r.Exit != nil {
+			return processExitCode(r.Exit)
+		}
 	}
+	return 1
+}
+
+// processExitCode maps a task exit result to the exit code of the harness:
+// the task's own exit code, or 128 plus the signal for a task killed by a
+// signal, as shells report it.
+func processExitCode(res *drivers.ExitResult) int {
+	if res.ExitCode == 0 && res.Signal > 0 {
+		return 128 + res.Signal
+	}
+	return res.ExitCode
+}
+
+// isFlagSet reports whether the flag name was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// awaitNetwork returns the network of a started task. StartTask may return
+// a nil or partial network while the container is still being attached, so
+// InspectTask is retried until it reports an IP. Host networking and tasks
+// without a network never get a driver network and are returned as is.
+func awaitNetwork(d drivers.DriverPlugin, taskID, mode string, dnet *drivers.DriverNetwork) *drivers.DriverNetwork {
+	if mode == "host" || mode == "none" || (dnet != nil && dnet.IP != "") {
+		return dnet
+	}
+
+	for i := 0; i < 5; i++ {
+		status, err := d.InspectTask(taskID)
+		if err == nil && status.NetworkOverride != nil && status.NetworkOverride.IP != "" {
+			return status.NetworkOverride
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return dnet
+}
+
+// printNetwork writes how the task can be reached from the host. mode is the
+// docker network mode of the task; bridge networks report the container IP
+// and how the reserved host ports map into the container, host networking has
+// no driver network and listens on the host ports directly. none is the alloc
+// network namespace with only loopback.
+func printNetwork(w io.Writer, mode string, task *drivers.TaskConfig, dnet *drivers.DriverNetwork) {
+	if mode == "" || mode == "default" {
+		mode = "bridge"
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	fmt.Fprintf(tw, "network:\n")
+	fmt.Fprintf(tw, "  mode\t%s\n", mode)
+
+	containerIP := ""
+	switch {
+	case mode == "host":
+		fmt.Fprintf(tw, "  ip\thost\n")
+	case mode == "none":
+		fmt.Fprintf(tw, "  ip\tloopback only\n")
+		return
+	case dnet == nil || dnet.IP == "":
+		fmt.Fprintf(tw, "  ip\tno network assigned\n")
+	default:
+		containerIP = dnet.IP
+		fmt.Fprintf(tw, "  ip\t%s\n", dnet.IP)
+		fmt.Fprintf(tw, "  advertise\t%t\n", dnet.AutoAdvertise)
+	}
+
+	if task.Resources == nil || task.Resources.NomadResources == nil {
+		return
+	}
+	for _, nw := range task.Resources.NomadResources.Networks {
+		ports := make([]structs.Port, 0, len(nw.ReservedPorts)+len(nw.DynamicPorts))
+		ports = append(ports, nw.ReservedPorts...)
+		ports = append(ports, nw.DynamicPorts...)
+
+		for _, p := range ports {
+			hostAddr := net.JoinHostPort(nw.IP, strconv.Itoa(p.Value))
+			if containerIP == "" {
+				fmt.Fprintf(tw, "  port %s\t%s\n", p.Label, hostAddr)
+				continue
+			}
+
+			to := p.Value
+			if dnet.PortMap[p.Label] != 0 {
+				to = dnet.PortMap[p.Label]
+			}
+			fmt.Fprintf(tw, "  port %s\t%s -> %s\n", p.Label, hostAddr, net.JoinHostPort(containerIP, strconv.Itoa(to)))
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+func TestAwaitNilNetwork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := hclog.NewNullLogger()
+	dh := harness.NewInProcess(logger, mockdriver.NewWithConfig(ctx, logger, mockdriver.Config{RunFor: time.Hour}))
+	defer dh.Close()
+
+	task, taskCfg, err := dh.NewTask(harness.DefaultTaskSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	// The mock driver never returns a network.
+	_, dnet, err := dh.StartTask(task)
+	if err != nil {
+		t.Fatalf("failed to start task: %v", err)
+	}
+	if dnet != nil {
+		t.Fatalf("mock driver returned network %+v", dnet)
+	}
+
+	if got := awaitNetwork(dh.DriverPlugin, task.ID, "", dnet); got != nil {
+		t.Errorf("network %+v, expected none", got)
+	}
+
+	var buf bytes.Buffer
+	printNetwork(&buf, "", task, nil)
+	if !strings.Contains(buf.String(), "no network assigned") {
+		t.Errorf("network printed as %q, expected no network assigned", buf.String())
+	}
+}
+
+func TestPrintPartialNetwork(t *testing.T) {
+	task := &drivers.TaskConfig{
+		Resources: &drivers.Resources{
+			NomadResources: &structs.AllocatedTaskResources{
+				Networks: structs.Networks{{
+					IP:           "127.0.0.1",
+					DynamicPorts: []structs.Port{{Label: "http", Value: 25000}},
+				}},
+			},
+		},
+	}
+
+	cases := []struct {
+		name string
+		mode string
+		dnet *drivers.DriverNetwork
+		want []string
+	}{
+		{
+			name: "nil",
+			want: []string{"no network assigned", "port http  127.0.0.1:25000"},
+		},
+		{
+			name: "no IP",
+			dnet: &drivers.DriverNetwork{PortMap: map[string]int{"http": 3000}},
+			want: []string{"no network assigned", "port http  127.0.0.1:25000"},
+		},
+		{
+			name: "complete",
+			dnet: &drivers.DriverNetwork{IP: "172.17.0.2", PortMap: map[string]int{"http": 3000}},
+			want: []string{"ip         172.17.0.2", "port http  127.0.0.1:25000 -> 172.17.0.2:3000"},
+		},
+		{
+			name: "host",
+			mode: "host",
+			want: []string{"ip         host"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			printNetwork(&buf, c.mode, task, c.dnet)
+			for _, want := range c.want {
+				if !strings.Contains(buf.String(), want) {
+					t.Errorf("network printed as %q, expected it to contain %q", buf.String(), want)
+				}
+			}
+		})
+	}
+}
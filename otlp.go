@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// otlpExporter posts spans to the OTLP/HTTP traces endpoint of a collector.
+// The request is encoded by hand from the OTLP trace protos, the generated
+// ones would pull in a newer gRPC than go-plugin and Nomad are built with.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	return &otlpExporter{
+		url:    "http://" + endpoint + "/v1/traces",
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ExportSpans implements sdktrace.SpanExporter.
+func (e *otlpExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(encodeTraces(spans)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to export spans: %s returned %s", e.url, resp.Status)
+	}
+	return nil
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (e *otlpExporter) Shutdown(context.Context) error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// encodeTraces encodes an ExportTraceServiceRequest holding spans. The
+// harness has a single tracer provider, so all spans share the resource of
+// the first one and are grouped by the library that recorded them.
+func encodeTraces(spans []sdktrace.ReadOnlySpan) []byte {
+	var libs []string
+	byLib := map[string][]byte{}
+	for _, s := range spans {
+		name := s.InstrumentationLibrary().Name
+		if _, ok := byLib[name]; !ok {
+			libs = append(libs, name)
+		}
+		byLib[name] = appendMessage(byLib[name], 2, encodeSpan(s))
+	}
+
+	// ResourceSpans
+	var rs []byte
+	if res := spans[0].Resource(); res != nil {
+		var r []byte
+		for _, kv := range res.Attributes() {
+			r = appendMessage(r, 1, encodeKeyValue(kv))
+		}
+		rs = appendMessage(rs, 1, r)
+		rs = appendString(rs, 3, res.SchemaURL())
+	}
+	for _, name := range libs {
+		// InstrumentationLibrarySpans
+		var l []byte
+		l = appendMessage(l, 1, appendString(nil, 1, name))
+		l = append(l, byLib[name]...)
+		rs = appendMessage(rs, 2, l)
+	}
+
+	return appendMessage(nil, 1, rs)
+}
+
+func encodeSpan(s sdktrace.ReadOnlySpan) []byte {
+	sc := s.SpanContext()
+	traceID, spanID := sc.TraceID(), sc.SpanID()
+
+	var b []byte
+	b = appendBytes(b, 1, traceID[:])
+	b = appendBytes(b, 2, spanID[:])
+	b = appendString(b, 3, sc.TraceState().String())
+	if p := s.Parent(); p.HasSpanID() {
+		parentID := p.SpanID()
+		b = appendBytes(b, 4, parentID[:])
+	}
+	b = appendString(b, 5, s.Name())
+	// The SpanKind values match the OTLP enum.
+	b = appendVarint(b, 6, uint64(s.SpanKind()))
+	b = appendFixed64(b, 7, uint64(s.StartTime().UnixNano()))
+	b = appendFixed64(b, 8, uint64(s.EndTime().UnixNano()))
+	for _, kv := range s.Attributes() {
+		b = appendMessage(b, 9, encodeKeyValue(kv))
+	}
+	b = appendVarint(b, 10, uint64(s.DroppedAttributes()))
+	for _, ev := range s.Events() {
+		var e []byte
+		e = appendFixed64(e, 1, uint64(ev.Time.UnixNano()))
+		e = appendString(e, 2, ev.Name)
+		for _, kv := range ev.Attributes {
+			e = appendMessage(e, 3, encodeKeyValue(kv))
+		}
+		b = appendMessage(b, 11, e)
+	}
+
+	// Status, OTLP numbers the codes as unset, ok, error.
+	var st []byte
+	st = appendString(st, 2, s.Status().Description)
+	switch s.Status().Code {
+	case codes.Ok:
+		st = appendVarint(st, 3, 1)
+	case codes.Error:
+		st = appendVarint(st, 3, 2)
+	}
+	return appendMessage(b, 15, st)
+}
+
+func encodeKeyValue(kv attribute.KeyValue) []byte {
+	b := appendString(nil, 1, string(kv.Key))
+	return appendMessage(b, 2, encodeValue(kv.Value))
+}
+
+// encodeValue encodes an AnyValue, slices become an ArrayValue.
+func encodeValue(v attribute.Value) []byte {
+	var b []byte
+	switch v.Type() {
+	case attribute.BOOL:
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(v.AsBool()))
+	case attribute.INT64:
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(v.AsInt64()))
+	case attribute.FLOAT64:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(v.AsFloat64()))
+	case attribute.BOOLSLICE, attribute.INT64SLICE, attribute.FLOAT64SLICE, attribute.STRINGSLICE:
+		var arr []byte
+		for _, elem := range sliceValues(v) {
+			arr = appendMessage(arr, 1, encodeValue(elem))
+		}
+		b = appendMessage(b, 5, arr)
+	default:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, v.Emit())
+	}
+	return b
+}
+
+func sliceValues(v attribute.Value) []attribute.Value {
+	var vals []attribute.Value
+	switch v.Type() {
+	case attribute.BOOLSLICE:
+		for _, e := range v.AsBoolSlice() {
+			vals = append(vals, attribute.BoolValue(e))
+		}
+	case attribute.INT64SLICE:
+		for _, e := range v.AsInt64Slice() {
+			vals = append(vals, attribute.Int64Value(e))
+		}
+	case attribute.FLOAT64SLICE:
+		for _, e := range v.AsFloat64Slice() {
+			vals = append(vals, attribute.Float64Value(e))
+		}
+	case attribute.STRINGSLICE:
+		for _, e := range v.AsStringSlice() {
+			vals = append(vals, attribute.StringValue(e))
+		}
+	}
+	return vals
+}
+
+// The append helpers skip zero values as proto3 does.
+
+func appendMessage(b []byte, num protowire.Number, m []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return appendMessage(b, num, v)
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// wireField is a decoded protobuf field, v holds varint and fixed64 values
+// and b length delimited ones.
+type wireField struct {
+	num protowire.Number
+	typ protowire.Type
+	v   uint64
+	b   []byte
+}
+
+func decodeWire(t *testing.T, b []byte) []wireField {
+	t.Helper()
+
+	var fields []wireField
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("invalid tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		f := wireField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			f.v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			f.v, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			f.b, n = protowire.ConsumeBytes(b)
+		default:
+			t.Fatalf("field %d has unexpected wire type %d", num, typ)
+		}
+		if n < 0 {
+			t.Fatalf("invalid field %d: %v", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// wireFields returns the fields num of the message b, which must have the
+// wire type typ.
+func wireFields(t *testing.T, b []byte, num protowire.Number, typ protowire.Type) []wireField {
+	t.Helper()
+
+	var fields []wireField
+	for _, f := range decodeWire(t, b) {
+		if f.num != num {
+			continue
+		}
+		if f.typ != typ {
+			t.Fatalf("field %d has wire type %d, expected %d", num, f.typ, typ)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// wireField1 returns the single field num of the message b.
+func wireField1(t *testing.T, b []byte, num protowire.Number, typ protowire.Type) wireField {
+	t.Helper()
+
+	fields := wireFields(t, b, num, typ)
+	if len(fields) != 1 {
+		t.Fatalf("found field %d %d times, expected once", num, len(fields))
+	}
+	return fields[0]
+}
+
+func TestEncodeTraces(t *testing.T) {
+	traceID := trace.TraceID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	spanID := trace.SpanID{1, 2, 3, 4, 5, 6, 7, 8}
+	parentID := trace.SpanID{8, 7, 6, 5, 4, 3, 2, 1}
+	start := time.Unix(1600000000, 1)
+	end := start.Add(time.Second)
+
+	span := tracetest.SpanStub{
+		Name: "StartTask",
+		SpanContext: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  spanID,
+		}),
+		Parent: trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID,
+			SpanID:  parentID,
+		}),
+		SpanKind:  trace.SpanKindClient,
+		StartTime: start,
+		EndTime:   end,
+		Attributes: []attribute.KeyValue{
+			attribute.String("task.id", "abc"),
+			attribute.Bool("oom", true),
+			attribute.Int64("exit_code", -1),
+			attribute.Float64("cpu", 1.5),
+			attribute.StringSlice("args", []string{"-l", "-p"}),
+		},
+		Events: []sdktrace.Event{{Name: "exception", Time: end}},
+		Status: sdktrace.Status{Code: codes.Error, Description: "boom"},
+		Resource: resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(tracingServiceName),
+		),
+		InstrumentationLibrary: instrumentation.Library{Name: "harness"},
+	}
+
+	req := encodeTraces([]sdktrace.ReadOnlySpan{span.Snapshot()})
+
+	// ExportTraceServiceRequest.resource_spans
+	rs := wireField1(t, req, 1, protowire.BytesType).b
+	if got := string(wireField1(t, rs, 3, protowire.BytesType).b); got != semconv.SchemaURL {
+		t.Errorf("schema url %q, expected %q", got, semconv.SchemaURL)
+	}
+	res := wireField1(t, rs, 1, protowire.BytesType).b
+	kv := wireField1(t, res, 1, protowire.BytesType).b
+	if got := string(wireField1(t, kv, 1, protowire.BytesType).b); got != "service.name" {
+		t.Errorf("resource attribute %q, expected service.name", got)
+	}
+
+	lib := wireField1(t, rs, 2, protowire.BytesType).b
+	libName := wireField1(t, wireField1(t, lib, 1, protowire.BytesType).b, 1, protowire.BytesType).b
+	if string(libName) != "harness" {
+		t.Errorf("instrumentation library %q, expected harness", libName)
+	}
+
+	s := wireField1(t, lib, 2, protowire.BytesType).b
+	for _, c := range []struct {
+		num  protowire.Number
+		want []byte
+	}{
+		{1, traceID[:]},
+		{2, spanID[:]},
+		{4, parentID[:]},
+		{5, []byte("StartTask")},
+	} {
+		if got := wireField1(t, s, c.num, protowire.BytesType).b; !bytes.Equal(got, c.want) {
+			t.Errorf("span field %d is %x, expected %x", c.num, got, c.want)
+		}
+	}
+	if got := wireField1(t, s, 6, protowire.VarintType).v; got != uint64(trace.SpanKindClient) {
+		t.Errorf("span kind %d, expected %d", got, trace.SpanKindClient)
+	}
+	if got := wireField1(t, s, 7, protowire.Fixed64Type).v; got != uint64(start.UnixNano()) {
+		t.Errorf("start time %d, expected %d", got, start.UnixNano())
+	}
+	if got := wireField1(t, s, 8, protowire.Fixed64Type).v; got != uint64(end.UnixNano()) {
+		t.Errorf("end time %d, expected %d", got, end.UnixNano())
+	}
+
+	// AnyValue fields by attribute.
+	attrs := wireFields(t, s, 9, protowire.BytesType)
+	if len(attrs) != len(span.Attributes) {
+		t.Fatalf("%d attributes, expected %d", len(attrs), len(span.Attributes))
+	}
+	values := []struct {
+		num protowire.Number
+		typ protowire.Type
+		v   uint64
+		b   string
+	}{
+		{1, protowire.BytesType, 0, "abc"},
+		{2, protowire.VarintType, 1, ""},
+		{3, protowire.VarintType, math.MaxUint64, ""},
+		{4, protowire.Fixed64Type, math.Float64bits(1.5), ""},
+		{5, protowire.BytesType, 0, ""},
+	}
+	for i, want := range values {
+		kv := attrs[i].b
+		key := string(wireField1(t, kv, 1, protowire.BytesType).b)
+		if key != string(span.Attributes[i].Key) {
+			t.Errorf("attribute %d key %q, expected %q", i, key, span.Attributes[i].Key)
+		}
+		got := wireField1(t, wireField1(t, kv, 2, protowire.BytesType).b, want.num, want.typ)
+		if got.v != want.v || (want.b != "" && string(got.b) != want.b) {
+			t.Errorf("attribute %s is %+v, expected %+v", key, got, want)
+		}
+	}
+	args := wireField1(t, wireField1(t, attrs[4].b, 2, protowire.BytesType).b, 5, protowire.BytesType).b
+	if elems := wireFields(t, args, 1, protowire.BytesType); len(elems) != 2 {
+		t.Errorf("%d array values, expected 2", len(elems))
+	}
+
+	event := wireField1(t, s, 11, protowire.BytesType).b
+	if got := string(wireField1(t, event, 2, protowire.BytesType).b); got != "exception" {
+		t.Errorf("event %q, expected exception", got)
+	}
+	if got := wireField1(t, event, 1, protowire.Fixed64Type).v; got != uint64(end.UnixNano()) {
+		t.Errorf("event time %d, expected %d", got, end.UnixNano())
+	}
+
+	status := wireField1(t, s, 15, protowire.BytesType).b
+	if got := string(wireField1(t, status, 2, protowire.BytesType).b); got != "boom" {
+		t.Errorf("status message %q, expected boom", got)
+	}
+	// STATUS_CODE_ERROR
+	if got := wireField1(t, status, 3, protowire.VarintType).v; got != 2 {
+		t.Errorf("status code %d, expected 2", got)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// outputFormats are the -output formats printState accepts.
+var outputFormats = map[string]bool{
+	"spew": true,
+	"json": true,
+	"text": true,
+}
+
+// stateLine is the json output of printState. The run result posted to
+// -webhook is made of the same lines, with how each task ended added.
+type stateLine struct {
+	Time             time.Time              `json:"time"`
+	ID               string                 `json:"id"`
+	Name             string                 `json:"name"`
+	State            string                 `json:"state"`
+	StartedAt        time.Time              `json:"started_at"`
+	ExitCode         *int                   `json:"exit_code,omitempty"`
+	DriverAttributes map[string]string      `json:"driver_attributes,omitempty"`
+	Network          *drivers.DriverNetwork `json:"network,omitempty"`
+
+	Outcome   string `json:"outcome,omitempty"`
+	Signal    int    `json:"signal,omitempty"`
+	OOMKilled bool   `json:"oom_killed,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newStateLine(status *drivers.TaskStatus) stateLine {
+	line := stateLine{
+		Time:             time.Now(),
+		ID:               status.ID,
+		Name:             status.Name,
+		State:            string(status.State),
+		StartedAt:        status.StartedAt,
+		DriverAttributes: status.DriverAttributes,
+		Network:          status.NetworkOverride,
+	}
+	if status.ExitResult != nil {
+		code := status.ExitResult.ExitCode
+		line.ExitCode = &code
+	}
+	return line
+}
+
+// newResultLine returns how the task of r ended, starting from the last
+// status Shutdown saw of it, if any.
+func newResultLine(r shutdownResult) stateLine {
+	line := stateLine{
+		Time:  time.Now(),
+		ID:    r.TaskID,
+		Name:  r.Name,
+		State: string(drivers.TaskStateUnknown),
+	}
+	if r.Status != nil {
+		line = newStateLine(r.Status)
+	}
+
+	line.Outcome = string(r.Outcome)
+	if r.Exit != nil {
+		code := r.Exit.ExitCode
+		line.State = string(drivers.TaskStateExited)
+		line.ExitCode = &code
+		line.Signal = r.Exit.Signal
+		line.OOMKilled = r.Exit.OOMKilled
+	}
+	if r.Err != nil {
+		line.Error = r.Err.Error()
+	}
+	return line
+}
+
+// printState writes the status of a task in format: spew dumps the whole
+// struct, json writes one compact object per call for jq and text writes a
+// single line with the state and the driver attribute keys.
+func printState(w io.Writer, format string, status *drivers.TaskStatus) error {
+	switch format {
+	case "spew":
+		spew.Fdump(w, status)
+	case "json":
+		return json.NewEncoder(w).Encode(newStateLine(status))
+	case "text":
+		keys := make([]string, 0, len(status.DriverAttributes))
+		for k := range status.DriverAttributes {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(w, "%s %s state=%s attributes=%s\n", time.Now().Format(time.RFC3339), status.Name, status.State, strings.Join(keys, ","))
+	default:
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return nil
+}
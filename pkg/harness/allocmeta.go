@@ -0,0 +1,65 @@
+package harness
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// allocMetaFile is written to the root of every alloc dir the harness builds.
+const allocMetaFile = "meta.json"
+
+// allocMeta records which run created an alloc dir, so leftover dirs can be
+// traced back to it and dirs of a live harness left alone.
+type allocMeta struct {
+	TaskName  string    `json:"task_name"`
+	TaskID    string    `json:"task_id"`
+	AllocID   string    `json:"alloc_id"`
+	Image     string    `json:"image,omitempty"`
+	Command   []string  `json:"command,omitempty"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// driverCommand holds the fields shared by the docker and exec driver task
+// configs.
+type driverCommand struct {
+	Image   string   `codec:"image"`
+	Command string   `codec:"command"`
+	Args    []string `codec:"args"`
+}
+
+// newAllocMeta describes t. Image and command are only known once the driver
+// config has been encoded into t.
+func newAllocMeta(t *drivers.TaskConfig) allocMeta {
+	m := allocMeta{
+		TaskName:  t.Name,
+		TaskID:    t.ID,
+		AllocID:   t.AllocID,
+		PID:       os.Getpid(),
+		StartedAt: time.Now().UTC(),
+	}
+
+	var cmd driverCommand
+	if err := t.DecodeDriverConfig(&cmd); err == nil {
+		m.Image = cmd.Image
+		if cmd.Command != "" {
+			m.Command = append([]string{cmd.Command}, cmd.Args...)
+		}
+	}
+
+	return m
+}
+
+// writeAllocMeta writes m to meta.json in allocDir.
+func writeAllocMeta(allocDir string, m allocMeta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(allocDir, allocMetaFile), b, 0644)
+}
@@ -0,0 +1,106 @@
+package harness
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+func TestAllocMetaRoundTrip(t *testing.T) {
+	dh := newMockHarness(t, mockdriver.Config{})
+	task := newMockTask(t, dh)
+
+	before := time.Now().UTC()
+	ad, err := dh.BuildAllocDir(task, false, "", "")
+	if err != nil {
+		t.Fatalf("failed to build alloc dir: %v", err)
+	}
+	defer ad.Destroy()
+
+	b, err := ioutil.ReadFile(filepath.Join(task.AllocDir, allocMetaFile))
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", allocMetaFile, err)
+	}
+	var got allocMeta
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("failed to parse %s: %v", allocMetaFile, err)
+	}
+
+	spec := DefaultTaskSpec()
+	want := allocMeta{
+		TaskName:  task.Name,
+		TaskID:    task.ID,
+		AllocID:   task.AllocID,
+		Image:     dh.TaskConfig([]string{spec.Command}).Image,
+		Command:   append([]string{spec.Command}, spec.Args...),
+		PID:       os.Getpid(),
+		StartedAt: got.StartedAt,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("meta %+v, expected %+v", got, want)
+	}
+	if got.StartedAt.Before(before) || got.StartedAt.After(time.Now().UTC()) {
+		t.Errorf("start time %s not within the build of the alloc dir", got.StartedAt)
+	}
+
+	// FindAllocDirs reads the same file back for the GC.
+	dirs, err := FindAllocDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		if dir.Path != task.AllocDir {
+			continue
+		}
+		if dir.AllocID != task.AllocID || dir.TaskName != task.Name || !dir.Live {
+			t.Errorf("found alloc dir %+v, expected live dir of alloc %s", dir, task.AllocID)
+		}
+		return
+	}
+	t.Errorf("alloc dir %s not found", task.AllocDir)
+}
+
+func TestBuildAllocDirFailure(t *testing.T) {
+	dh := newMockHarness(t, mockdriver.Config{})
+	task, taskCfg, err := dh.NewTask(DefaultTaskSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fails in the task dir, after the alloc dir was built.
+	taskCfg.LoadImage = "../busybox.tar"
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	if ad, err := dh.BuildAllocDir(task, false, "", ""); err == nil {
+		ad.Destroy()
+		t.Fatal("built an alloc dir loading an image from outside the task dir")
+	}
+	if _, err := os.Stat(task.AllocDir); !os.IsNotExist(err) {
+		t.Errorf("alloc dir %s left behind: %v", task.AllocDir, err)
+	}
+}
+
+func TestRemoveAllocDir(t *testing.T) {
+	dh := newMockHarness(t, mockdriver.Config{})
+	task := newMockTask(t, dh)
+
+	ad, err := dh.BuildAllocDir(task, false, "", "")
+	if err != nil {
+		t.Fatalf("failed to build alloc dir: %v", err)
+	}
+	defer ad.Destroy()
+
+	// As the GC and -recover do, without the task dirs the harness built.
+	if err := RemoveAllocDir(hclog.NewNullLogger(), task.AllocDir); err != nil {
+		t.Fatalf("failed to remove alloc dir: %v", err)
+	}
+	if _, err := os.Stat(task.AllocDir); !os.IsNotExist(err) {
+		t.Errorf("alloc dir %s left behind: %v", task.AllocDir, err)
+	}
+}
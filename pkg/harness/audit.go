@@ -0,0 +1,207 @@
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// Audit event names.
+const (
+	auditStartTask   = "start_task"
+	auditTaskStarted = "task_started"
+	auditStartFailed = "start_failed"
+	auditStopTask    = "stop_task"
+	auditTaskExited  = "task_exited"
+)
+
+// auditEvent is one line of the audit file.
+type auditEvent struct {
+	Time        string `json:"time"`
+	Event       string `json:"event"`
+	TaskID      string `json:"task_id"`
+	AllocID     string `json:"alloc_id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	ContainerID string `json:"container_id,omitempty"`
+	Signal      string `json:"signal,omitempty"`
+	Timeout     string `json:"timeout,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	ExitSignal  int    `json:"exit_signal,omitempty"`
+	OOMKilled   bool   `json:"oom_killed,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// AuditLog appends the task starts, stops and exits of a harness to a file,
+// one JSON object per line. It is safe for concurrent use.
+type AuditLog struct {
+	mu    sync.Mutex
+	f     *os.File
+	enc   *json.Encoder
+	tasks map[string]*drivers.TaskConfig
+	// exited holds the tasks whose exit was recorded, a task is waited on
+	// more than once but exits only once.
+	exited map[string]bool
+}
+
+// OpenAuditLog opens the audit file at path for appending, creating it if it
+// does not exist. The log must be closed to sync it to disk.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit file: %v", err)
+	}
+	return &AuditLog{
+		f:      f,
+		enc:    json.NewEncoder(f),
+		tasks:  make(map[string]*drivers.TaskConfig),
+		exited: make(map[string]bool),
+	}, nil
+}
+
+// WithAuditLog records the StartTask, StopTask and exit results of every task
+// of the harness in a, including calls made on its DriverPlugin directly.
+func WithAuditLog(a *AuditLog) Option {
+	return func(h *DriverHarness) {
+		h.audit = a
+	}
+}
+
+// Close syncs the audit file to disk and closes it.
+func (a *AuditLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.f.Sync(); err != nil {
+		a.f.Close()
+		return fmt.Errorf("failed to sync audit file: %v", err)
+	}
+	return a.f.Close()
+}
+
+// record writes ev for taskID, filling in the task fields known from its
+// start. A failed write is not reported, the audit must not fail tasks.
+func (a *AuditLog) record(taskID string, ev auditEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if ev.Event == auditTaskExited {
+		if a.exited[taskID] {
+			return
+		}
+		a.exited[taskID] = true
+	}
+
+	ev.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	ev.TaskID = taskID
+	if t, ok := a.tasks[taskID]; ok {
+		ev.AllocID, ev.Name = t.AllocID, t.Name
+	}
+	a.enc.Encode(ev)
+}
+
+// startTask records a StartTask call for cfg.
+func (a *AuditLog) startTask(cfg *drivers.TaskConfig) {
+	a.mu.Lock()
+	a.tasks[cfg.ID] = cfg
+	delete(a.exited, cfg.ID)
+	a.mu.Unlock()
+
+	a.record(cfg.ID, auditEvent{Event: auditStartTask})
+}
+
+// handleContainer is the container ID field of the docker driver state.
+type handleContainer struct {
+	ContainerID string
+}
+
+// taskStarted records the result of the StartTask call for cfg.
+func (a *AuditLog) taskStarted(cfg *drivers.TaskConfig, handle *drivers.TaskHandle, err error) {
+	if err != nil {
+		a.record(cfg.ID, auditEvent{Event: auditStartFailed, Error: err.Error()})
+		return
+	}
+
+	ev := auditEvent{Event: auditTaskStarted}
+	var state handleContainer
+	if handle != nil && handle.GetDriverState(&state) == nil {
+		ev.ContainerID = state.ContainerID
+	}
+	a.record(cfg.ID, ev)
+}
+
+// auditedDriver is a driver whose task starts, stops and exits are recorded
+// in an audit log.
+type auditedDriver struct {
+	drivers.DriverPlugin
+	audit *AuditLog
+}
+
+// auditDriver returns d recording into the audit log of the harness, or d
+// itself without one.
+func (h *DriverHarness) auditDriver(d drivers.DriverPlugin) drivers.DriverPlugin {
+	if h.audit == nil {
+		return d
+	}
+	return &auditedDriver{DriverPlugin: d, audit: h.audit}
+}
+
+func (d *auditedDriver) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
+	d.audit.startTask(cfg)
+	handle, dnet, err := d.DriverPlugin.StartTask(cfg)
+	d.audit.taskStarted(cfg, handle, err)
+	return handle, dnet, err
+}
+
+func (d *auditedDriver) StopTask(taskID string, timeout time.Duration, signal string) error {
+	err := d.DriverPlugin.StopTask(taskID, timeout, signal)
+	ev := auditEvent{Event: auditStopTask, Signal: signal, Timeout: timeout.String()}
+	if err != nil {
+		ev.Error = err.Error()
+	}
+	d.audit.record(taskID, ev)
+	return err
+}
+
+// WaitTask records the exit result of the task before passing it on. Results
+// of a wait which failed, e.g. because ctx is done, are not exits.
+func (d *auditedDriver) WaitTask(ctx context.Context, taskID string) (<-chan *drivers.ExitResult, error) {
+	ch, err := d.DriverPlugin.WaitTask(ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(chan *drivers.ExitResult, 1)
+	go func() {
+		defer close(out)
+		res, ok := <-ch
+		if !ok {
+			return
+		}
+		if res != nil && res.Err == nil {
+			code := res.ExitCode
+			d.audit.record(taskID, auditEvent{
+				Event:      auditTaskExited,
+				ExitCode:   &code,
+				ExitSignal: res.Signal,
+				OOMKilled:  res.OOMKilled,
+			})
+		}
+		out <- res
+	}()
+	return out, nil
+}
+
+// ExecTaskStreamingRaw passes streaming exec on to the wrapped driver, which
+// embedding the DriverPlugin interface alone would hide.
+func (d *auditedDriver) ExecTaskStreamingRaw(ctx context.Context, taskID string, command []string, tty bool, stream drivers.ExecTaskStream) error {
+	raw, ok := d.DriverPlugin.(drivers.ExecTaskStreamingRawDriver)
+	if !ok {
+		return fmt.Errorf("driver does not support streaming exec")
+	}
+	return raw.ExecTaskStreamingRaw(ctx, taskID, command, tty, stream)
+}
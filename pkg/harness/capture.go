@@ -0,0 +1,71 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// RunAndCapture starts task, waits for it to exit and returns everything it
+// wrote to stdout and stderr along with its exit result. The task driver
+// config must already be encoded. The task and its alloc dir are destroyed
+// before returning.
+func (h *DriverHarness) RunAndCapture(ctx context.Context, task *drivers.TaskConfig) (stdout, stderr string, result *drivers.ExitResult, err error) {
+	ad, err := h.BuildAllocDir(task, true, "", "")
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer ad.Destroy()
+
+	if _, _, err := h.StartTask(task); err != nil {
+		ad.StopLogs()
+		return "", "", nil, fmt.Errorf("failed to start task: %v", err)
+	}
+
+	result, err = h.WaitTask(ctx, task.ID)
+
+	// Destroying the task stops the driver streaming logs into the fifos,
+	// stopping logmon afterwards flushes them to the log files.
+	if derr := h.DestroyTask(task.ID, true); derr != nil && err == nil {
+		err = fmt.Errorf("failed to destroy task: %v", derr)
+	}
+	ad.StopLogs()
+	if err != nil {
+		return "", "", result, err
+	}
+
+	stdout, err = ReadLogFiles(ad.taskDir.LogDir, ad.stdoutFile)
+	if err != nil {
+		return "", "", result, err
+	}
+	stderr, err = ReadLogFiles(ad.taskDir.LogDir, ad.stderrFile)
+	if err != nil {
+		return stdout, "", result, err
+	}
+
+	return stdout, stderr, result, nil
+}
+
+// ReadLogFiles concatenates the rotated logmon files <base>.0, <base>.1, ...
+// in dir in index order.
+func ReadLogFiles(dir, base string) (string, error) {
+	idxs, err := logFileIndexes(dir, base)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	for _, idx := range idxs {
+		b, err := ioutil.ReadFile(filepath.Join(dir, fmt.Sprintf("%s.%d", base, idx)))
+		if err != nil {
+			return "", err
+		}
+		buf.Write(b)
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,67 @@
+package harness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/drivers/rawexec"
+	"github.com/hashicorp/nomad/plugins/base"
+)
+
+// newRawExecHarness returns a harness running raw_exec in process, closed
+// when the test ends. Its executor is the test binary, which the executor
+// package serves from init.
+func newRawExecHarness(t testing.TB) *DriverHarness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := hclog.NewNullLogger()
+	dh := NewInProcess(logger, rawexec.NewRawExecDriver(ctx, logger), WithCommandTasks())
+	t.Cleanup(dh.Close)
+
+	var data []byte
+	if err := base.MsgPackEncode(&data, &rawexec.Config{Enabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := dh.SetConfig(&base.Config{PluginConfig: data}); err != nil {
+		t.Fatalf("failed to configure raw_exec: %v", err)
+	}
+	return dh
+}
+
+func TestRunAndCapture(t *testing.T) {
+	dh := newRawExecHarness(t)
+
+	spec := DefaultTaskSpec()
+	spec.Command = "/bin/sh"
+	spec.Args = []string{"-c", "echo hello; echo world; echo oops >&2; exit 3"}
+	task, taskCfg, err := dh.NewTask(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.EncodeTaskConfig(task, taskCfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	stdout, stderr, res, err := dh.RunAndCapture(ctx, task)
+	if err != nil {
+		t.Fatalf("failed to run task: %v", err)
+	}
+
+	if stdout != "hello\nworld\n" {
+		t.Errorf("stdout %q, expected %q", stdout, "hello\nworld\n")
+	}
+	if stderr != "oops\n" {
+		t.Errorf("stderr %q, expected %q", stderr, "oops\n")
+	}
+	if res.ExitCode != 3 {
+		t.Errorf("exit code %d, expected 3", res.ExitCode)
+	}
+	if _, ok := dh.Handle(task.ID); ok {
+		t.Error("harness kept the handle of the captured task")
+	}
+}
@@ -0,0 +1,59 @@
+package harness
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// minEventBackoff and maxEventBackoff bound the wait before the event
+	// stream is subscribed to again after it failed.
+	minEventBackoff = 500 * time.Millisecond
+	maxEventBackoff = 10 * time.Second
+)
+
+// WatchEvents logs the task events of allocID until ctx is done. An empty
+// allocID logs the events of every task. The stream is subscribed to again
+// with a backoff whenever it fails or ends.
+func (h *DriverHarness) WatchEvents(ctx context.Context, allocID string) error {
+	logger := h.logger.Named("events")
+	backoff := minEventBackoff
+
+	for {
+		h.mu.RLock()
+		closed := h.closed
+		h.mu.RUnlock()
+		if closed {
+			return ErrClosed
+		}
+
+		events, err := h.DriverPlugin.TaskEvents(ctx)
+		if err != nil {
+			logger.Warn("failed to subscribe to task events", "error", err, "backoff", backoff)
+		} else {
+			for ev := range events {
+				if ev.Err != nil {
+					logger.Warn("task event stream failed", "error", ev.Err, "backoff", backoff)
+					break
+				}
+				backoff = minEventBackoff
+				if allocID != "" && ev.AllocID != allocID {
+					continue
+				}
+				logger.Info("task event", "timestamp", ev.Timestamp, "task", ev.TaskName,
+					"task_id", ev.TaskID, "message", ev.Message, "annotations", ev.Annotations)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxEventBackoff {
+			backoff = maxEventBackoff
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/hashicorp/nomad/plugins/drivers/proto"
+)
+
+// ExecStreaming runs cmd in the task with in, out and errw attached and
+// returns its exit result. Cancelling ctx ends the exec session.
+func (h *DriverHarness) ExecStreaming(ctx context.Context, taskID string, cmd []string, in io.Reader, out, errw io.Writer) (*drivers.ExitResult, error) {
+	return h.execStreaming(ctx, taskID, cmd, false, in, out, errw, nil)
+}
+
+// ExecStreamingTTY is ExecStreaming with a pseudo terminal allocated. The
+// terminal merges stderr into out, and every size sent on resize is applied
+// to it.
+func (h *DriverHarness) ExecStreamingTTY(ctx context.Context, taskID string, cmd []string, in io.Reader, out io.Writer, resize <-chan drivers.TerminalSize) (*drivers.ExitResult, error) {
+	return h.execStreaming(ctx, taskID, cmd, true, in, out, out, resize)
+}
+
+func (h *DriverHarness) execStreaming(ctx context.Context, taskID string, cmd []string, tty bool, in io.Reader, out, errw io.Writer, resize <-chan drivers.TerminalSize) (*drivers.ExitResult, error) {
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	caps, err := h.DriverPlugin.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+	if !caps.Exec {
+		return nil, fmt.Errorf("driver does not support exec")
+	}
+
+	raw, ok := h.DriverPlugin.(drivers.ExecTaskStreamingRawDriver)
+	if !ok {
+		return nil, fmt.Errorf("driver does not support streaming exec")
+	}
+
+	// Cancelled on return so the driver client stops reading from the stream.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream := newExecStream(ctx, out, errw)
+	if in != nil {
+		go stream.pumpStdin(in)
+	}
+	if resize != nil {
+		go stream.pumpResize(resize)
+	}
+
+	if err := raw.ExecTaskStreamingRaw(ctx, taskID, cmd, tty, stream); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to exec in task: %v", err)
+	}
+	if stream.result == nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("exec in task %s ended without an exit result", taskID)
+	}
+
+	return stream.result, nil
+}
+
+// execStream implements drivers.ExecTaskStream over plain readers and
+// writers.
+type execStream struct {
+	ctx    context.Context
+	reqCh  chan *drivers.ExecTaskStreamingRequestMsg
+	out    io.Writer
+	errw   io.Writer
+	result *drivers.ExitResult
+}
+
+func newExecStream(ctx context.Context, out, errw io.Writer) *execStream {
+	return &execStream{
+		ctx:   ctx,
+		reqCh: make(chan *drivers.ExecTaskStreamingRequestMsg),
+		out:   out,
+		errw:  errw,
+	}
+}
+
+// Send receives output and the exit result from the driver.
+func (s *execStream) Send(m *drivers.ExecTaskStreamingResponseMsg) error {
+	if m.Stdout != nil && len(m.Stdout.Data) > 0 {
+		if _, err := s.out.Write(m.Stdout.Data); err != nil {
+			return err
+		}
+	}
+	if m.Stderr != nil && len(m.Stderr.Data) > 0 {
+		if _, err := s.errw.Write(m.Stderr.Data); err != nil {
+			return err
+		}
+	}
+	if m.Exited && m.Result != nil {
+		s.result = &drivers.ExitResult{
+			ExitCode:  int(m.Result.ExitCode),
+			Signal:    int(m.Result.Signal),
+			OOMKilled: m.Result.OomKilled,
+		}
+	}
+	return nil
+}
+
+// Recv hands stdin and resize messages to the driver until the context is
+// done.
+func (s *execStream) Recv() (*drivers.ExecTaskStreamingRequestMsg, error) {
+	select {
+	case m := <-s.reqCh:
+		return m, nil
+	case <-s.ctx.Done():
+		return nil, io.EOF
+	}
+}
+
+// send queues m for Recv and reports false once the context is done.
+func (s *execStream) send(m *drivers.ExecTaskStreamingRequestMsg) bool {
+	select {
+	case s.reqCh <- m:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// pumpStdin forwards in to the task and closes the task's stdin at EOF. A
+// Read blocked on in is not interrupted when the session ends.
+func (s *execStream) pumpStdin(in io.Reader) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			// the driver client sends the message after Recv returns, so
+			// buf must not be reused underneath it
+			data := append([]byte(nil), buf[:n]...)
+			if !s.send(&drivers.ExecTaskStreamingRequestMsg{
+				Stdin: &proto.ExecTaskStreamingIOOperation{Data: data},
+			}) {
+				return
+			}
+		}
+		if err != nil {
+			s.send(&drivers.ExecTaskStreamingRequestMsg{
+				Stdin: &proto.ExecTaskStreamingIOOperation{Close: true},
+			})
+			return
+		}
+	}
+}
+
+// pumpResize forwards terminal size changes to the task.
+func (s *execStream) pumpResize(resize <-chan drivers.TerminalSize) {
+	for {
+		select {
+		case size, ok := <-resize:
+			if !ok {
+				return
+			}
+			if !s.send(&drivers.ExecTaskStreamingRequestMsg{
+				TtySize: &proto.ExecTaskStreamingRequest_TerminalSize{
+					Height: int32(size.Height),
+					Width:  int32(size.Width),
+				},
+			}) {
+				return
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
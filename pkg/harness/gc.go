@@ -0,0 +1,115 @@
+package harness
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/client/allocdir"
+)
+
+// AllocDirPrefix starts the names of the alloc dirs the harness builds in the
+// temp dir.
+const AllocDirPrefix = "nomad_driver_harness-"
+
+// ManagedByLabel is set to ManagedBy on the docker containers of harness
+// tasks, so leftover ones can be told apart from other containers.
+const (
+	ManagedByLabel = "managed-by"
+	ManagedBy      = "go-plugin-hashi-example"
+)
+
+// newAllocDirGrace is how long an alloc dir without meta.json is assumed to
+// still be built by a live harness.
+const newAllocDirGrace = time.Minute
+
+// FoundAllocDir is an alloc dir some harness run built in the temp dir.
+type FoundAllocDir struct {
+	Path string
+
+	// TaskName, AllocID and PID are read from meta.json, they are empty for
+	// dirs without one.
+	TaskName string
+	AllocID  string
+	PID      int
+
+	// Live is set when the harness which built the dir is still running,
+	// or the dir has no meta.json and was created just now.
+	Live bool
+}
+
+// FindAllocDirs returns the alloc dirs harness runs left in the temp dir,
+// including those of live runs.
+func FindAllocDirs() ([]FoundAllocDir, error) {
+	paths, err := filepath.Glob(filepath.Join(os.TempDir(), AllocDirPrefix+"*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []FoundAllocDir
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.IsDir() {
+			continue
+		}
+
+		dir := FoundAllocDir{Path: path}
+		b, err := ioutil.ReadFile(filepath.Join(path, allocMetaFile))
+		var m allocMeta
+		if err == nil && json.Unmarshal(b, &m) == nil {
+			dir.TaskName, dir.AllocID, dir.PID = m.TaskName, m.AllocID, m.PID
+			dir.Live = processAlive(m.PID)
+		} else {
+			dir.Live = time.Since(fi.ModTime()) < newAllocDirGrace
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, nil
+}
+
+// RemoveAllocDir destroys the alloc dir in path the way the harness does when
+// its task is done, unmounting the task dirs first.
+func RemoveAllocDir(logger hclog.Logger, path string) error {
+	allocDir := allocdir.NewAllocDir(logger, path)
+	addTaskDirs(allocDir)
+	return allocDir.Destroy()
+}
+
+// addTaskDirs adds the task dirs found in allocDir which it does not know
+// yet, so Destroy unmounts their secrets dirs too. Without them the mounts
+// are left behind and keep the alloc dir from being removed.
+func addTaskDirs(allocDir *allocdir.AllocDir) {
+	entries, err := ioutil.ReadDir(allocDir.AllocDir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || e.Name() == allocdir.SharedAllocName {
+			continue
+		}
+		if _, ok := allocDir.TaskDirs[e.Name()]; !ok {
+			allocDir.NewTaskDir(e.Name())
+		}
+	}
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	if pid == os.Getpid() {
+		return true
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,897 @@
+// Package harness runs Nomad task driver plugins outside of Nomad. It
+// launches a driver plugin, builds the alloc and task dirs Nomad would and
+// drives tasks through the dispensed driver.
+package harness
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/nomad/client/allocdir"
+	"github.com/hashicorp/nomad/client/config"
+	"github.com/hashicorp/nomad/client/logmon"
+	"github.com/hashicorp/nomad/client/taskenv"
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/nomad/mock"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// DriverHarness keeps a single plugin client and its dispensed driver alive
+// so many task operations can be run against it. Operations are safe for
+// concurrent use and fail once the harness is closed.
+type DriverHarness struct {
+	drivers.DriverPlugin
+	logger hclog.Logger
+	impl   drivers.DriverPlugin
+
+	client    *plugin.Client
+	mu        sync.RWMutex
+	closed    bool
+	closeOnce sync.Once
+
+	// keepPlugin is set when the plugin was already running, it is not
+	// killed on Close.
+	keepPlugin bool
+
+	// startupTimeout bounds connecting to the plugin and dispensing its
+	// driver, zero waits forever.
+	startupTimeout time.Duration
+
+	// skipChroot stops MkAllocDir populating the chroot for drivers with
+	// chroot filesystem isolation.
+	skipChroot bool
+
+	// logmonProcess runs logmon out of process, see WithLogmonProcess.
+	logmonProcess bool
+	// logmonDialOptions are applied to the gRPC connections to logmon
+	// processes, see WithGRPCDialOptions.
+	logmonDialOptions []grpc.DialOption
+
+	// audit records task starts, stops and exits, see WithAuditLog.
+	audit *AuditLog
+
+	// netManager creates the network namespaces of drivers which must
+	// initiate the network, nil if the driver does not implement it.
+	netManager drivers.DriverNetworkManager
+
+	// taskSpec is the task config schema of the driver once fetched by
+	// ValidateTaskConfig.
+	specMu   sync.Mutex
+	taskSpec hcldec.Spec
+
+	// loadImage is the image tar tasks load, see WithLoadImage.
+	loadImage string
+
+	// tracer records spans around the driver calls, driverName is set on
+	// all of them.
+	tracer     trace.Tracer
+	driverName string
+
+	resources  *drivers.Resources
+	taskConfig func(command []string) docker.TaskConfig
+
+	// commandTasks makes EncodeTaskConfig encode only the command, see
+	// WithCommandTasks.
+	commandTasks bool
+
+	// handles are the tasks started or recovered through the harness, by
+	// task ID. Destroying a task through the harness forgets it.
+	handlesMu sync.Mutex
+	handles   map[string]*drivers.TaskHandle
+}
+
+// ErrClosed is returned by operations on a closed harness.
+var ErrClosed = errors.New("driver harness is closed")
+
+// Option configures a DriverHarness.
+type Option func(*DriverHarness)
+
+// WithResources sets the resources returned by Resources, DefaultResources
+// is used otherwise.
+func WithResources(r *drivers.Resources) Option {
+	return func(h *DriverHarness) {
+		h.resources = r
+	}
+}
+
+// WithTaskConfig sets the func TaskConfig builds docker task configs with,
+// DefaultTaskConfig is used otherwise.
+func WithTaskConfig(f func(command []string) docker.TaskConfig) Option {
+	return func(h *DriverHarness) {
+		h.taskConfig = f
+	}
+}
+
+// WithCommandTasks makes EncodeTaskConfig encode only the command and args of
+// task configs, for drivers like exec and raw_exec which run a command on the
+// host instead of a docker image.
+func WithCommandTasks() Option {
+	return func(h *DriverHarness) {
+		h.commandTasks = true
+	}
+}
+
+// KeepPlugin stops Close from killing the plugin. Use it for clients which
+// reattached to a plugin the harness did not launch, closing their
+// connection would shut the plugin down too.
+func KeepPlugin() Option {
+	return func(h *DriverHarness) {
+		h.keepPlugin = true
+	}
+}
+
+// WithStartupTimeout makes New give up on a plugin which does not handshake
+// and dispense its driver within d. Launched plugins are only killed once the
+// client's own start timeout passes, see PluginOptions.
+func WithStartupTimeout(d time.Duration) Option {
+	return func(h *DriverHarness) {
+		h.startupTimeout = d
+	}
+}
+
+// New connects client to its plugin and dispenses the driver. impl is the
+// driver served by the plugin binary. The returned harness must be closed to
+// kill the plugin.
+func New(logger hclog.Logger, client *plugin.Client, impl drivers.DriverPlugin, opts ...Option) (*DriverHarness, error) {
+	h := &DriverHarness{
+		logger:     logger,
+		impl:       impl,
+		client:     client,
+		resources:  DefaultResources(),
+		taskConfig: DefaultTaskConfig,
+		handles:    make(map[string]*drivers.TaskHandle),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.initTracing()
+
+	d, err := h.dispense(client)
+	if err != nil {
+		if !h.keepPlugin {
+			client.Kill()
+		}
+		return nil, err
+	}
+	h.DriverPlugin = h.auditDriver(d)
+	h.netManager, _ = d.(drivers.DriverNetworkManager)
+
+	logger.Info("connected to plugin", "protocol", client.Protocol(), "version", client.NegotiatedVersion())
+	return h, nil
+}
+
+// NewInProcess returns a harness which calls d directly instead of through a
+// plugin process. It is meant for drivers like mockdriver which need no
+// plugin binary.
+func NewInProcess(logger hclog.Logger, d drivers.DriverPlugin, opts ...Option) *DriverHarness {
+	h := &DriverHarness{
+		DriverPlugin: d,
+		logger:       logger,
+		impl:         d,
+		resources:    DefaultResources(),
+		taskConfig:   DefaultTaskConfig,
+		handles:      make(map[string]*drivers.TaskHandle),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.initTracing()
+	h.DriverPlugin = h.auditDriver(d)
+	h.netManager, _ = d.(drivers.DriverNetworkManager)
+
+	logger.Info("running driver in process")
+	return h
+}
+
+// Resources returns the resources tasks of this harness are given.
+func (h *DriverHarness) Resources() *drivers.Resources {
+	return h.resources
+}
+
+// TaskConfig returns the docker task config running command.
+func (h *DriverHarness) TaskConfig(command []string) docker.TaskConfig {
+	cfg := h.taskConfig(command)
+	if h.loadImage != "" {
+		cfg.LoadImage = filepath.Base(h.loadImage)
+	}
+	return cfg
+}
+
+// commandTaskConfig is the task config of the exec and raw_exec drivers.
+type commandTaskConfig struct {
+	Command string   `codec:"command"`
+	Args    []string `codec:"args"`
+}
+
+// EncodeTaskConfig encodes cfg as the driver config of t, labelling its
+// container with ManagedByLabel. With WithCommandTasks only its command and
+// args are kept.
+func (h *DriverHarness) EncodeTaskConfig(t *drivers.TaskConfig, cfg docker.TaskConfig) {
+	if h.commandTasks {
+		t.EncodeConcreteDriverConfig(&commandTaskConfig{Command: cfg.Command, Args: cfg.Args})
+		return
+	}
+
+	// Copied so the labels of the harness task config are not changed.
+	labels := make(map[string]string, len(cfg.Labels)+1)
+	for k, v := range cfg.Labels {
+		labels[k] = v
+	}
+	labels[ManagedByLabel] = ManagedBy
+	cfg.Labels = labels
+	t.EncodeConcreteDriverConfig(&cfg)
+}
+
+// DefaultResources returns 250 MHz of CPU and 256 MB of memory.
+func DefaultResources() *drivers.Resources {
+	return &drivers.Resources{
+		NomadResources: &structs.AllocatedTaskResources{
+			Memory: structs.AllocatedMemoryResources{
+				MemoryMB: 256,
+			},
+			Cpu: structs.AllocatedCpuResources{
+				CpuShares: 250,
+			},
+		},
+		LinuxResources: &drivers.LinuxResources{
+			CPUShares:        512,
+			MemoryLimitBytes: 256 * 1024 * 1024,
+		},
+	}
+}
+
+// CPUPeriod is the CFS period NewResources hard limits the CPU with.
+const CPUPeriod = 100000
+
+// NewResources returns memoryMB of memory and cpuShares of CPU. A non-zero
+// cpuLimit caps the task at that percent of the total host CPU; the docker
+// driver only applies it when cpu_hard_limit is set in the task config.
+func NewResources(memoryMB, cpuShares int64, cpuLimit float64) (*drivers.Resources, error) {
+	if memoryMB <= 0 {
+		return nil, fmt.Errorf("memory must be positive, got %d MB", memoryMB)
+	}
+	if cpuShares <= 0 {
+		return nil, fmt.Errorf("cpu shares must be positive, got %d", cpuShares)
+	}
+	if cpuLimit < 0 || cpuLimit > 100 {
+		return nil, fmt.Errorf("cpu limit must be between 0 and 100 percent, got %g", cpuLimit)
+	}
+
+	r := &drivers.Resources{
+		NomadResources: &structs.AllocatedTaskResources{
+			Memory: structs.AllocatedMemoryResources{
+				MemoryMB: memoryMB,
+			},
+			Cpu: structs.AllocatedCpuResources{
+				CpuShares: cpuShares,
+			},
+		},
+		LinuxResources: &drivers.LinuxResources{
+			CPUShares:        cpuShares,
+			MemoryLimitBytes: memoryMB * 1024 * 1024,
+		},
+	}
+	if cpuLimit > 0 {
+		r.LinuxResources.CPUPeriod = CPUPeriod
+		r.LinuxResources.PercentTicks = cpuLimit / 100
+	}
+	return r, nil
+}
+
+// DefaultImageTar is the busybox image tar DefaultTaskConfig loads, in the
+// working dir.
+const DefaultImageTar = "busybox.tar"
+
+// DefaultTaskConfig runs command in the busybox image loaded from
+// busybox.tar.
+func DefaultTaskConfig(command []string) docker.TaskConfig {
+	return NewTaskConfig("", command)
+}
+
+// NewTaskConfig runs command in busybox, or the given variant of it, loaded
+// from the matching image tar. Without the tar in the working dir the image
+// is pulled instead.
+func NewTaskConfig(variant string, command []string) docker.TaskConfig {
+	// busyboxImageID is the ID stored in busybox.tar
+	busyboxImageID := "busybox:1.29.3"
+
+	image := busyboxImageID
+	loadImage := DefaultImageTar
+	if variant != "" {
+		image = fmt.Sprintf("%s-%s", busyboxImageID, variant)
+		loadImage = fmt.Sprintf("busybox_%s.tar", variant)
+	}
+	if _, err := os.Stat(loadImage); err != nil {
+		loadImage = ""
+	}
+
+	return docker.TaskConfig{
+		Image:            image,
+		ImagePullTimeout: "5m",
+		LoadImage:        loadImage,
+		Command:          command[0],
+		Args:             command[1:],
+	}
+}
+
+// Close kills the plugin client. A reattached plugin is left running, as
+// closing its connection would shut it down too. It is safe to call more than
+// once.
+func (h *DriverHarness) Close() {
+	h.closeOnce.Do(func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		h.closed = true
+		if h.client != nil && !h.keepPlugin {
+			h.client.Kill()
+		}
+	})
+}
+
+// SkipChroot makes MkAllocDir skip copying host binaries into the task
+// chroot. It is only valid for drivers which do not run from an image; the
+// task must then not need anything from the host filesystem.
+func (h *DriverHarness) SkipChroot(skip bool) error {
+	caps, err := h.Capabilities()
+	if err != nil {
+		return err
+	}
+	if skip && caps.FSIsolation == drivers.FSIsolationImage {
+		return fmt.Errorf("driver uses %s filesystem isolation and never builds a chroot", caps.FSIsolation)
+	}
+
+	h.skipChroot = skip
+	return nil
+}
+
+// StartTask starts a task unless the harness is closed or its driver config
+// fails ValidateTaskConfig and keeps its handle.
+func (h *DriverHarness) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return nil, nil, ErrClosed
+	}
+	if err := h.ValidateTaskConfig(cfg); err != nil {
+		return nil, nil, err
+	}
+
+	ctx, span := h.startSpan(context.Background(), "StartTask", cfg.ID)
+	untrack := trackCall(ctx, "StartTask", cfg.ID)
+	handle, dnet, err := h.DriverPlugin.StartTask(cfg)
+	untrack()
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+	h.trackTask(handle)
+	return handle, dnet, nil
+}
+
+// Handle returns the handle of a task started or recovered through the
+// harness.
+func (h *DriverHarness) Handle(taskID string) (*drivers.TaskHandle, bool) {
+	h.handlesMu.Lock()
+	defer h.handlesMu.Unlock()
+
+	handle, ok := h.handles[taskID]
+	return handle, ok
+}
+
+// TaskIDs returns the IDs of the tasks the harness keeps handles for.
+func (h *DriverHarness) TaskIDs() []string {
+	h.handlesMu.Lock()
+	defer h.handlesMu.Unlock()
+
+	ids := make([]string, 0, len(h.handles))
+	for id := range h.handles {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *DriverHarness) trackTask(handle *drivers.TaskHandle) {
+	if handle == nil || handle.Config == nil {
+		return
+	}
+
+	h.handlesMu.Lock()
+	defer h.handlesMu.Unlock()
+	h.handles[handle.Config.ID] = handle
+}
+
+// StopTask stops a task unless the harness is closed.
+func (h *DriverHarness) StopTask(taskID string, timeout time.Duration, signal string) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return ErrClosed
+	}
+
+	ctx, span := h.startSpan(context.Background(), "StopTask", taskID)
+	untrack := trackCall(ctx, "StopTask", taskID)
+	err := h.DriverPlugin.StopTask(taskID, timeout, signal)
+	untrack()
+	endSpan(span, err)
+	return err
+}
+
+// DestroyTask destroys a task unless the harness is closed and forgets its
+// handle.
+func (h *DriverHarness) DestroyTask(taskID string, force bool) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return ErrClosed
+	}
+	if err := h.DriverPlugin.DestroyTask(taskID, force); err != nil {
+		return err
+	}
+
+	h.handlesMu.Lock()
+	delete(h.handles, taskID)
+	h.handlesMu.Unlock()
+	return nil
+}
+
+// knownSignals are the signals SignalTask accepts.
+var knownSignals = map[string]bool{
+	"SIGHUP":   true,
+	"SIGINT":   true,
+	"SIGQUIT":  true,
+	"SIGKILL":  true,
+	"SIGUSR1":  true,
+	"SIGUSR2":  true,
+	"SIGTERM":  true,
+	"SIGCONT":  true,
+	"SIGSTOP":  true,
+	"SIGTSTP":  true,
+	"SIGWINCH": true,
+}
+
+// SignalTask sends signal to a task unless the harness is closed. Unknown
+// signal names are rejected before reaching the driver.
+func (h *DriverHarness) SignalTask(taskID string, signal string) error {
+	if !knownSignals[signal] {
+		return fmt.Errorf("unknown signal %q", signal)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return ErrClosed
+	}
+	return h.DriverPlugin.SignalTask(taskID, signal)
+}
+
+// ExecTask runs a command in a task unless the harness is closed.
+func (h *DriverHarness) ExecTask(taskID string, cmd []string, timeout time.Duration) (*drivers.ExecTaskResult, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return nil, ErrClosed
+	}
+	return h.DriverPlugin.ExecTask(taskID, cmd, timeout)
+}
+
+// WaitTask blocks until the task exits or ctx is done and returns its exit
+// result. The harness lock is not held while waiting, so Close is not
+// delayed by a long running task.
+func (h *DriverHarness) WaitTask(ctx context.Context, taskID string) (res *drivers.ExitResult, err error) {
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	ctx, span := h.startSpan(ctx, "WaitTask", taskID)
+	defer func() { endSpan(span, err) }()
+
+	ch, err := h.DriverPlugin.WaitTask(ctx, taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait on task: %v", err)
+	}
+
+	select {
+	case res, ok := <-ch:
+		if !ok || res == nil {
+			return nil, fmt.Errorf("task %s wait channel closed without a result", taskID)
+		}
+		return res, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// PluginOptions configure how NewPluginClient launches the plugin.
+type PluginOptions struct {
+	// AutoMTLS negotiates mutual TLS for the gRPC connection.
+	AutoMTLS bool
+
+	// Stderr, if not nil, gets a copy of the raw plugin stderr.
+	Stderr io.Writer
+
+	// StartTimeout kills the plugin if it does not handshake in time. Use
+	// the same value with WithStartupTimeout, zero is go-plugin's default of
+	// a minute.
+	StartTimeout time.Duration
+
+	// Checksum is the SHA-256 the plugin binary must have, it is not
+	// launched otherwise. Empty skips the check.
+	Checksum []byte
+
+	// GRPCDialOptions are applied to the gRPC connection to the plugin after
+	// go-plugin's own and the interceptors propagating the trace context,
+	// e.g. keepalive parameters or message size limits.
+	GRPCDialOptions []grpc.DialOption
+}
+
+// NewPluginClient returns a go-plugin client which launches the driver plugin
+// binary at path and talks to it over gRPC. The plugin logs are relogged
+// through a "plugin" sub-logger of logger, filtered by its level.
+func NewPluginClient(logger hclog.Logger, d drivers.DriverPlugin, path string, opts PluginOptions) *plugin.Client {
+	cfg := pluginClientConfig(logger, d)
+	cfg.Cmd = exec.Command(path)
+	cfg.AutoMTLS = opts.AutoMTLS
+	cfg.Stderr = opts.Stderr
+	cfg.StartTimeout = opts.StartTimeout
+	cfg.GRPCDialOptions = append(tracingDialOptions(), opts.GRPCDialOptions...)
+	if len(opts.Checksum) > 0 {
+		cfg.SecureConfig = &plugin.SecureConfig{
+			Checksum: opts.Checksum,
+			Hash:     sha256.New(),
+		}
+	}
+	return plugin.NewClient(cfg)
+}
+
+// NewReattachClient returns a go-plugin client which connects to the running
+// plugin in reattach, applying dialOpts to the gRPC connection. Pass
+// KeepPlugin to New with it.
+func NewReattachClient(logger hclog.Logger, d drivers.DriverPlugin, reattach *plugin.ReattachConfig, dialOpts ...grpc.DialOption) *plugin.Client {
+	cfg := pluginClientConfig(logger, d)
+	cfg.Reattach = reattach
+	cfg.GRPCDialOptions = append(tracingDialOptions(), dialOpts...)
+	return plugin.NewClient(cfg)
+}
+
+func pluginClientConfig(logger hclog.Logger, d drivers.DriverPlugin) *plugin.ClientConfig {
+	return &plugin.ClientConfig{
+		HandshakeConfig: base.Handshake,
+		Logger:          logger.Named("plugin"),
+		Plugins: plugin.PluginSet{
+			base.PluginTypeDriver: drivers.NewDriverPlugin(d, logger),
+			base.PluginTypeBase:   &base.PluginBase{Impl: d},
+			"logmon":              logmon.NewPlugin(logmon.NewLogMon(logger.Named("logmon"))),
+		},
+
+		AllowedProtocols: []plugin.Protocol{
+			plugin.ProtocolGRPC,
+		},
+	}
+}
+
+// dispense runs dispenseDriver within the startup timeout. A plugin which
+// never handshakes, e.g. a binary built for another arch, would otherwise
+// block it forever.
+func (h *DriverHarness) dispense(client *plugin.Client) (drivers.DriverPlugin, error) {
+	if h.startupTimeout <= 0 {
+		return dispenseDriver(client)
+	}
+
+	type result struct {
+		d   drivers.DriverPlugin
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		d, err := dispenseDriver(client)
+		ch <- result{d, err}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.startupTimeout)
+	defer cancel()
+
+	select {
+	case r := <-ch:
+		return r.d, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("plugin did not become ready within %s", h.startupTimeout)
+	}
+}
+
+// dispenseDriver connects to the plugin process and returns its driver client.
+func dispenseDriver(client *plugin.Client) (drivers.DriverPlugin, error) {
+	rpcClient, err := client.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := rpcClient.Dispense(base.PluginTypeDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	return raw.(drivers.DriverPlugin), nil
+}
+
+// MkAllocDir creates a temporary directory and allocdir structure.
+// If enableLogs is set to true a logmon instance will be started to write logs
+// to the LogDir of the task, into stdoutFile and stderrFile. Empty file names
+// default to <task>.stdout and <task>.stderr.
+// A cleanup func is returned and should be deferred so as to not leak dirs
+// between tests.
+func (h *DriverHarness) MkAllocDir(t *drivers.TaskConfig, enableLogs bool, stdoutFile, stderrFile string) (func(), error) {
+	ad, err := h.BuildAllocDir(t, enableLogs, stdoutFile, stderrFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ad.StopLogs()
+		ad.Destroy()
+	}, nil
+}
+
+// TaskAllocDir is an alloc dir built for a task along with the logmon
+// instance collecting its logs, if any.
+type TaskAllocDir struct {
+	allocDir   *allocdir.AllocDir
+	taskDir    *allocdir.TaskDir
+	logmon     logmon.LogMon
+	stdoutFile string
+	stderrFile string
+
+	// logmonClient is the client of the logmon process, nil when logmon
+	// runs in the harness.
+	logmonClient *plugin.Client
+}
+
+// StopLogs stops log collection, flushing buffered output to the log files.
+func (a *TaskAllocDir) StopLogs() {
+	if a.logmon != nil {
+		a.logmon.Stop()
+	}
+	if a.logmonClient != nil {
+		a.logmonClient.Kill()
+	}
+}
+
+// Destroy removes the alloc dir, along with the task dirs of sidecars added
+// to it.
+func (a *TaskAllocDir) Destroy() {
+	addTaskDirs(a.allocDir)
+	a.allocDir.Destroy()
+}
+
+// LogDir returns the dir the task logs are written to.
+func (a *TaskAllocDir) LogDir() string {
+	return a.taskDir.LogDir
+}
+
+// StdoutFile returns the base name of the task stdout log files in LogDir.
+func (a *TaskAllocDir) StdoutFile() string {
+	return a.stdoutFile
+}
+
+// StderrFile returns the base name of the task stderr log files in LogDir.
+func (a *TaskAllocDir) StderrFile() string {
+	return a.stderrFile
+}
+
+// BuildAllocDir is MkAllocDir for callers which need the log files or want
+// to stop log collection and remove the dir separately.
+func (h *DriverHarness) BuildAllocDir(t *drivers.TaskConfig, enableLogs bool, stdoutFile, stderrFile string) (*TaskAllocDir, error) {
+	stdoutFile, stderrFile = LogFileNames(t, stdoutFile, stderrFile)
+	if err := validateLogFileName(stdoutFile); err != nil {
+		return nil, err
+	}
+	if err := validateLogFileName(stderrFile); err != nil {
+		return nil, err
+	}
+
+	dir, err := ioutil.TempDir("", AllocDirPrefix)
+	if err != nil {
+		return nil, err
+	}
+	t.AllocDir = dir
+
+	// The dir is removed again if anything below fails.
+	allocDir := allocdir.NewAllocDir(h.logger, dir)
+	err = allocDir.Build()
+	if err != nil {
+		allocDir.Destroy()
+		return nil, err
+	}
+
+	if err := writeAllocMeta(dir, newAllocMeta(t)); err != nil {
+		allocDir.Destroy()
+		return nil, fmt.Errorf("failed to write alloc dir metadata: %v", err)
+	}
+
+	ad, err := h.mkTaskDir(allocDir, t, enableLogs, stdoutFile, stderrFile)
+	if err != nil {
+		allocDir.Destroy()
+		return nil, err
+	}
+	return ad, nil
+}
+
+// MkSidecarDir adds a task dir for sidecar to the alloc dir MkAllocDir built
+// for main, so both tasks share the alloc dir and its logs. The returned
+// cleanup only stops the sidecar's log collection, the alloc dir is removed by
+// the cleanup of main.
+func (h *DriverHarness) MkSidecarDir(main, sidecar *drivers.TaskConfig, enableLogs bool) (func(), error) {
+	if main.AllocDir == "" {
+		return nil, fmt.Errorf("task %q has no alloc dir", main.Name)
+	}
+	if sidecar.Name == main.Name {
+		return nil, fmt.Errorf("sidecar must not share the name %q of the main task", main.Name)
+	}
+
+	sidecar.AllocID = main.AllocID
+	sidecar.AllocDir = main.AllocDir
+
+	stdoutFile, stderrFile := LogFileNames(sidecar, "", "")
+	ad, err := h.mkTaskDir(allocdir.NewAllocDir(h.logger, main.AllocDir), sidecar, enableLogs, stdoutFile, stderrFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ad.StopLogs, nil
+}
+
+// LogFileNames defaults empty log file names to <task>.stdout and
+// <task>.stderr.
+func LogFileNames(t *drivers.TaskConfig, stdoutFile, stderrFile string) (string, string) {
+	if stdoutFile == "" {
+		stdoutFile = fmt.Sprintf("%s.stdout", t.Name)
+	}
+	if stderrFile == "" {
+		stderrFile = fmt.Sprintf("%s.stderr", t.Name)
+	}
+	return stdoutFile, stderrFile
+}
+
+// mkTaskDir builds the task dir and environment of t inside allocDir and
+// optionally starts logmon for it.
+func (h *DriverHarness) mkTaskDir(allocDir *allocdir.AllocDir, t *drivers.TaskConfig, enableLogs bool, stdoutFile, stderrFile string) (*TaskAllocDir, error) {
+	taskDir := allocDir.NewTaskDir(t.Name)
+
+	caps, err := h.Capabilities()
+	if err != nil {
+		return nil, err
+	}
+
+	fsi := caps.FSIsolation
+	buildChroot := fsi == drivers.FSIsolationChroot && !h.skipChroot
+	if fsi == drivers.FSIsolationChroot && h.skipChroot {
+		h.logger.Warn("skipping chroot population", "task", t.Name)
+	}
+	err = taskDir.Build(buildChroot, config.DefaultChrootEnv)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.stageLoadImage(t, taskDir.LocalDir); err != nil {
+		return nil, err
+	}
+
+	task := &structs.Task{
+		Name: t.Name,
+		Env:  t.Env,
+	}
+
+	// Create the mock allocation
+	alloc := mock.Alloc()
+	if t.AllocID != "" {
+		alloc.ID = t.AllocID
+	}
+	if t.Resources != nil {
+		alloc.AllocatedResources.Tasks[task.Name] = t.Resources.NomadResources
+	}
+
+	taskBuilder := taskenv.NewBuilder(mock.Node(), alloc, task, "global")
+	SetEnvvars(taskBuilder, fsi, taskDir, config.DefaultConfig())
+
+	taskEnv := taskBuilder.Build()
+	if t.Env == nil {
+		t.Env = taskEnv.Map()
+	} else {
+		for k, v := range taskEnv.Map() {
+			if _, ok := t.Env[k]; !ok {
+				t.Env[k] = v
+			}
+		}
+	}
+
+	ad := &TaskAllocDir{
+		allocDir:   allocDir,
+		taskDir:    taskDir,
+		stdoutFile: stdoutFile,
+		stderrFile: stderrFile,
+	}
+
+	//logmon
+	if enableLogs {
+		t.StdoutPath, t.StderrPath = logPipePaths(t, taskDir.LogDir)
+		err = h.startLogmon(ad, &logmon.LogConfig{
+			LogDir:        taskDir.LogDir,
+			StdoutLogFile: stdoutFile,
+			StderrLogFile: stderrFile,
+			StdoutFifo:    t.StdoutPath,
+			StderrFifo:    t.StderrPath,
+			MaxFiles:      10,
+			MaxFileSizeMB: 10,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ad, nil
+}
+
+// validateLogFileName ensures a log file name stays inside the task LogDir.
+func validateLogFileName(name string) error {
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid log file name %q: must be a plain file name", name)
+	}
+	return nil
+}
+
+// SetEnvvars sets path and host env vars depending on the FS isolation used.
+func SetEnvvars(envBuilder *taskenv.Builder, fsi drivers.FSIsolation, taskDir *allocdir.TaskDir, conf *config.Config) {
+
+	envBuilder.SetClientTaskRoot(taskDir.Dir)
+	envBuilder.SetClientSharedAllocDir(taskDir.SharedAllocDir)
+	envBuilder.SetClientTaskLocalDir(taskDir.LocalDir)
+	envBuilder.SetClientTaskSecretsDir(taskDir.SecretsDir)
+
+	// Set driver-specific environment variables
+	switch fsi {
+	case drivers.FSIsolationNone:
+		// Use host paths
+		envBuilder.SetAllocDir(taskDir.SharedAllocDir)
+		envBuilder.SetTaskLocalDir(taskDir.LocalDir)
+		envBuilder.SetSecretsDir(taskDir.SecretsDir)
+	default:
+		// filesystem isolation; use container paths
+		envBuilder.SetAllocDir(allocdir.SharedAllocContainerPath)
+		envBuilder.SetTaskLocalDir(allocdir.TaskLocalContainerPath)
+		envBuilder.SetSecretsDir(allocdir.TaskSecretsContainerPath)
+	}
+
+	// Set the host environment variables for non-image based drivers
+	if fsi != drivers.FSIsolationImage {
+		// COMPAT(1.0) using inclusive language, blacklist is kept for backward compatibility.
+		filter := strings.Split(conf.ReadAlternativeDefault(
+			[]string{"env.denylist", "env.blacklist"},
+			config.DefaultEnvDenylist,
+		), ",")
+		envBuilder.SetHostEnvvars(filter)
+	}
+}
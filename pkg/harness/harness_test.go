@@ -0,0 +1,168 @@
+package harness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+// newMockHarness returns a harness over a mock driver configured with cfg,
+// closed when the test ends.
+func newMockHarness(t testing.TB, cfg mockdriver.Config) *DriverHarness {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	logger := hclog.NewNullLogger()
+	dh := NewInProcess(logger, mockdriver.NewWithConfig(ctx, logger, cfg))
+	t.Cleanup(dh.Close)
+	return dh
+}
+
+// newMockTask returns the default task with its driver config encoded.
+func newMockTask(t testing.TB, dh *DriverHarness) *drivers.TaskConfig {
+	t.Helper()
+
+	task, taskCfg, err := dh.NewTask(DefaultTaskSpec())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dh.EncodeTaskConfig(task, taskCfg)
+	return task
+}
+
+func TestTaskExitResult(t *testing.T) {
+	cases := []struct {
+		name   string
+		config mockdriver.Config
+		// stop, if set, is the signal the task is stopped with.
+		stop string
+
+		exitCode int
+		signal   int
+		err      string
+	}{
+		{
+			name: "exit zero",
+		},
+		{
+			name:     "exit code",
+			config:   mockdriver.Config{ExitResult: &drivers.ExitResult{ExitCode: 3}},
+			exitCode: 3,
+		},
+		{
+			name:   "exit error",
+			config: mockdriver.Config{ExitResult: &drivers.ExitResult{ExitCode: 1, Err: errors.New("task failed")}},
+			// The mock driver passes the result on as is.
+			exitCode: 1,
+			err:      "task failed",
+		},
+		{
+			name:   "stop SIGINT",
+			config: mockdriver.Config{RunFor: time.Hour},
+			stop:   "SIGINT",
+			signal: 2,
+		},
+		{
+			name:   "stop SIGTERM",
+			config: mockdriver.Config{RunFor: time.Hour},
+			stop:   "SIGTERM",
+			signal: 15,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dh := newMockHarness(t, c.config)
+			task := newMockTask(t, dh)
+
+			if _, _, err := dh.StartTask(task); err != nil {
+				t.Fatalf("failed to start task: %v", err)
+			}
+			if _, ok := dh.Handle(task.ID); !ok {
+				t.Fatal("harness did not keep the task handle")
+			}
+			if c.stop != "" {
+				if err := dh.StopTask(task.ID, time.Second, c.stop); err != nil {
+					t.Fatalf("failed to stop task: %v", err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			res, err := dh.WaitTask(ctx, task.ID)
+			if err != nil {
+				t.Fatalf("failed to wait on task: %v", err)
+			}
+
+			if res.ExitCode != c.exitCode {
+				t.Errorf("exit code %d, expected %d", res.ExitCode, c.exitCode)
+			}
+			if res.Signal != c.signal {
+				t.Errorf("signal %d, expected %d", res.Signal, c.signal)
+			}
+			switch {
+			case c.err == "" && res.Err != nil:
+				t.Errorf("unexpected exit error: %v", res.Err)
+			case c.err != "" && (res.Err == nil || res.Err.Error() != c.err):
+				t.Errorf("exit error %v, expected %q", res.Err, c.err)
+			}
+
+			if err := dh.DestroyTask(task.ID, true); err != nil {
+				t.Fatalf("failed to destroy task: %v", err)
+			}
+			if _, ok := dh.Handle(task.ID); ok {
+				t.Error("harness kept the handle of the destroyed task")
+			}
+		})
+	}
+}
+
+func TestClosedHarness(t *testing.T) {
+	dh := newMockHarness(t, mockdriver.Config{})
+	task := newMockTask(t, dh)
+	dh.Close()
+
+	if _, _, err := dh.StartTask(task); !errors.Is(err, ErrClosed) {
+		t.Fatalf("StartTask on a closed harness returned %v, expected %v", err, ErrClosed)
+	}
+}
+
+// BenchmarkStartStop measures starting, stopping and destroying a task
+// against the one driver of a harness, as -bench does with the plugin.
+func BenchmarkStartStop(b *testing.B) {
+	dh := newMockHarness(b, mockdriver.Config{RunFor: time.Hour})
+	task := newMockTask(b, dh)
+
+	var start, stop time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t := task.Copy()
+		t.ID = fmt.Sprintf("%s-%d", task.ID, i)
+
+		begin := time.Now()
+		if _, _, err := dh.StartTask(t); err != nil {
+			b.Fatalf("failed to start task: %v", err)
+		}
+		started := time.Now()
+		if err := dh.StopTask(t.ID, time.Second, "SIGINT"); err != nil {
+			b.Fatalf("failed to stop task: %v", err)
+		}
+		if err := dh.DestroyTask(t.ID, true); err != nil {
+			b.Fatalf("failed to destroy task: %v", err)
+		}
+		start += started.Sub(begin)
+		stop += time.Since(started)
+	}
+	b.StopTimer()
+
+	b.ReportMetric(float64(start.Nanoseconds())/float64(b.N), "start-ns/op")
+	b.ReportMetric(float64(stop.Nanoseconds())/float64(b.N), "stop-ns/op")
+}
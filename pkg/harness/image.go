@@ -0,0 +1,78 @@
+package harness
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// WithLoadImage makes tasks load their image from the docker image tar at
+// path instead of busybox.tar. The tar must hold the image the task config
+// names.
+func WithLoadImage(path string) Option {
+	return func(h *DriverHarness) {
+		h.loadImage = path
+	}
+}
+
+// driverLoadImage is the image tar field of the docker task config.
+type driverLoadImage struct {
+	LoadImage string `codec:"load"`
+}
+
+// stageLoadImage puts the image tar the driver config of t loads into
+// localDir, where the docker driver looks for it. The tar is read from the
+// WithLoadImage path, or else relative to the working dir. Tasks without a
+// driver config yet, or without a tar to load, are left alone.
+func (h *DriverHarness) stageLoadImage(t *drivers.TaskConfig, localDir string) error {
+	var cfg driverLoadImage
+	if err := t.DecodeDriverConfig(&cfg); err != nil || cfg.LoadImage == "" {
+		return nil
+	}
+
+	rel := filepath.Clean(cfg.LoadImage)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("invalid load %q: must stay inside the task local dir", cfg.LoadImage)
+	}
+
+	src := cfg.LoadImage
+	if h.loadImage != "" && filepath.Base(h.loadImage) == rel {
+		src = h.loadImage
+	}
+	dst := filepath.Join(localDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	// A hard link saves copying a large tar, but needs the same filesystem.
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	if err := copyFile(src, dst); err != nil {
+		return fmt.Errorf("failed to stage image tar %s: %v", src, err)
+	}
+	return nil
+}
+
+// copyFile copies the file src to dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
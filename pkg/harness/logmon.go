@@ -0,0 +1,212 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/nomad/client/allocdir"
+	"github.com/hashicorp/nomad/client/logmon"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	pstructs "github.com/hashicorp/nomad/plugins/shared/structs"
+	"google.golang.org/grpc"
+)
+
+// logmonStateFile is written to the task dir when logmon runs in its own
+// process, so a later harness can re-attach to it.
+const logmonStateFile = "logmon.json"
+
+// logmonState is how to reach the logmon process of a task and what it was
+// started with.
+type logmonState struct {
+	Reattach *pstructs.ReattachConfig `json:"reattach"`
+	Config   *logmon.LogConfig        `json:"config"`
+}
+
+// WithLogmonProcess runs the logmon of every task with logs in its own
+// process instead of inside the harness, so it outlives a harness which dies
+// and can be re-attached to with RecoverLogs. The process is the harness
+// binary itself, run with the logmon argument.
+func WithLogmonProcess() Option {
+	return func(h *DriverHarness) {
+		h.logmonProcess = true
+	}
+}
+
+// WithGRPCDialOptions applies opts to the gRPC connections to the logmon
+// processes of WithLogmonProcess. The connection to the driver plugin takes
+// its options from the client, see PluginOptions.
+func WithGRPCDialOptions(opts ...grpc.DialOption) Option {
+	return func(h *DriverHarness) {
+		h.logmonDialOptions = opts
+	}
+}
+
+// startLogmon starts collecting the logs of the task of ad as cfg says, in
+// the harness or in a logmon process.
+func (h *DriverHarness) startLogmon(ad *TaskAllocDir, cfg *logmon.LogConfig) error {
+	if !h.logmonProcess {
+		lm := logmon.NewLogMon(h.logger.Named("logmon"))
+		if err := startLogs(lm, cfg); err != nil {
+			return err
+		}
+		ad.logmon = lm
+		return nil
+	}
+
+	lm, client, err := h.launchLogmon(nil)
+	if err != nil {
+		return fmt.Errorf("failed to launch logmon: %v", err)
+	}
+	if err := startLogs(lm, cfg); err != nil {
+		client.Kill()
+		return err
+	}
+	ad.logmon, ad.logmonClient = lm, client
+
+	if err := writeLogmonState(ad.taskDir.Dir, client.ReattachConfig(), cfg); err != nil {
+		h.logger.Warn("failed to save logmon reattach config, its logs cannot be recovered", "error", err)
+	}
+	return nil
+}
+
+// RecoverLogs re-attaches to the logmon process collecting the logs of t, a
+// task started by an earlier harness with WithLogmonProcess. A logmon which
+// is gone is launched again on the same fifos; output the task wrote while
+// none was running is lost. Tasks without a logmon process return nil.
+func (h *DriverHarness) RecoverLogs(t *drivers.TaskConfig) (*TaskAllocDir, error) {
+	if t.AllocDir == "" {
+		return nil, fmt.Errorf("task %q has no alloc dir", t.Name)
+	}
+
+	allocDir := allocdir.NewAllocDir(h.logger, t.AllocDir)
+	taskDir := allocDir.NewTaskDir(t.Name)
+
+	state, err := readLogmonState(taskDir.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	reattach, err := pstructs.ReattachConfigToGoPlugin(state.Reattach)
+	if err != nil {
+		return nil, fmt.Errorf("invalid logmon reattach config: %v", err)
+	}
+
+	lm, client, err := h.launchLogmon(reattach)
+	if err != nil {
+		h.logger.Warn("failed to re-attach to logmon, launching a new one", "error", err)
+		if lm, client, err = h.launchLogmon(nil); err != nil {
+			return nil, fmt.Errorf("failed to launch logmon: %v", err)
+		}
+	}
+
+	// A no-op for a logmon which is still collecting.
+	if err := startLogs(lm, state.Config); err != nil {
+		client.Kill()
+		return nil, err
+	}
+	if err := writeLogmonState(taskDir.Dir, client.ReattachConfig(), state.Config); err != nil {
+		h.logger.Warn("failed to save logmon reattach config", "error", err)
+	}
+
+	return &TaskAllocDir{
+		allocDir:     allocDir,
+		taskDir:      taskDir,
+		logmon:       lm,
+		logmonClient: client,
+		stdoutFile:   state.Config.StdoutLogFile,
+		stderrFile:   state.Config.StderrLogFile,
+	}, nil
+}
+
+// launchLogmon is logmon.LaunchLogMon with the dial options of the harness:
+// it launches the harness binary as a logmon process, or re-attaches to the
+// one in reattach if set.
+func (h *DriverHarness) launchLogmon(reattach *plugin.ReattachConfig) (logmon.LogMon, *plugin.Client, error) {
+	cfg := &plugin.ClientConfig{
+		HandshakeConfig: base.Handshake,
+		Logger:          h.logger.Named("logmon"),
+		Plugins: map[string]plugin.Plugin{
+			"logmon": &logmon.Plugin{},
+		},
+		AllowedProtocols: []plugin.Protocol{
+			plugin.ProtocolGRPC,
+		},
+		GRPCDialOptions: h.logmonDialOptions,
+	}
+	if reattach != nil {
+		cfg.Reattach = reattach
+	} else {
+		bin, err := os.Executable()
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.Cmd = exec.Command(bin, "logmon")
+	}
+
+	// The process is killed again unless logmon is dispensed.
+	client := plugin.NewClient(cfg)
+	rpcClient, err := client.Client()
+	if err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+	raw, err := rpcClient.Dispense("logmon")
+	if err != nil {
+		client.Kill()
+		return nil, nil, err
+	}
+	lm, ok := raw.(logmon.LogMon)
+	if !ok {
+		client.Kill()
+		return nil, nil, fmt.Errorf("unexpected logmon plugin type %T", raw)
+	}
+	return lm, client, nil
+}
+
+// LogmonReattachConfig returns how to reach the logmon process of the task,
+// nil if logmon runs in the harness or logs are not collected.
+func (a *TaskAllocDir) LogmonReattachConfig() *plugin.ReattachConfig {
+	if a.logmonClient == nil {
+		return nil
+	}
+	return a.logmonClient.ReattachConfig()
+}
+
+// writeLogmonState writes the logmon reattach config and cfg to taskDir.
+func writeLogmonState(taskDir string, reattach *plugin.ReattachConfig, cfg *logmon.LogConfig) error {
+	b, err := json.MarshalIndent(logmonState{
+		Reattach: pstructs.ReattachConfigFromGoPlugin(reattach),
+		Config:   cfg,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(taskDir, logmonStateFile), b, 0600)
+}
+
+// readLogmonState reads the state writeLogmonState wrote to taskDir.
+func readLogmonState(taskDir string) (*logmonState, error) {
+	path := filepath.Join(taskDir, logmonStateFile)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state logmonState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("failed to decode logmon state %s: %v", path, err)
+	}
+	if state.Reattach == nil || state.Config == nil {
+		return nil, fmt.Errorf("logmon state %s is incomplete", path)
+	}
+	return &state, nil
+}
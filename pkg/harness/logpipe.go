@@ -0,0 +1,90 @@
+package harness
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/nomad/client/logmon"
+	"github.com/hashicorp/nomad/helper/uuid"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// windowsPipePrefix is the namespace of Windows named pipes, in the form
+// nomad uses for task log pipes.
+const windowsPipePrefix = "//./pipe/"
+
+// logPipePaths returns where the driver writes the stdout and stderr of t
+// for logmon to read: named pipes on Windows, fifos in logDir elsewhere.
+func logPipePaths(t *drivers.TaskConfig, logDir string) (string, string) {
+	if runtime.GOOS == "windows" {
+		id := uuid.Generate()[:8]
+		return fmt.Sprintf("%s%s-%s.stdout", windowsPipePrefix, t.Name, id),
+			fmt.Sprintf("%s%s-%s.stderr", windowsPipePrefix, t.Name, id)
+	}
+	return filepath.Join(logDir, fmt.Sprintf(".%s.stdout.fifo", t.Name)),
+		filepath.Join(logDir, fmt.Sprintf(".%s.stderr.fifo", t.Name))
+}
+
+// startLogs starts lm on cfg, checking its pipes can be used before and were
+// created after. logmon only logs a pipe it fails to open, so the task would
+// otherwise run without logs.
+func startLogs(lm logmon.LogMon, cfg *logmon.LogConfig) error {
+	for _, path := range []string{cfg.StdoutFifo, cfg.StderrFifo} {
+		if err := checkLogPipe(path); err != nil {
+			return err
+		}
+	}
+
+	if err := lm.Start(cfg); err != nil {
+		return fmt.Errorf("failed to start logmon on %s and %s: %v", cfg.StdoutFifo, cfg.StderrFifo, err)
+	}
+
+	// Opening a Windows pipe to check it would take the one connection
+	// logmon accepts on it; logmon.Start already failed if it could not be
+	// created.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	for _, path := range []string{cfg.StdoutFifo, cfg.StderrFifo} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("logmon did not create fifo %s: %v", path, err)
+		}
+		if fi.Mode()&os.ModeNamedPipe == 0 {
+			return fmt.Errorf("log pipe %s is not a fifo", path)
+		}
+	}
+	return nil
+}
+
+// checkLogPipe errors if logmon cannot create or read the pipe at path.
+// Windows pipes must be named //./pipe/<name>, fifos elsewhere may exist
+// already, e.g. for a relaunched logmon, but only as fifos.
+func checkLogPipe(path string) error {
+	if runtime.GOOS == "windows" {
+		name := strings.TrimPrefix(path, windowsPipePrefix)
+		if name == path {
+			return fmt.Errorf("invalid log pipe %q: must start with %s", path, windowsPipePrefix)
+		}
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid log pipe %q: must be a plain pipe name", path)
+		}
+		return nil
+	}
+
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		// logmon creates it
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("invalid log pipe %s: %v", path, err)
+	}
+	if fi.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("log pipe %s exists and is not a fifo", path)
+	}
+	return nil
+}
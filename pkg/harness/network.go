@@ -0,0 +1,85 @@
+package harness
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// Network modes of SetupNetwork, named as in the network block of a Nomad
+// job.
+const (
+	// NetworkModeHost runs the task in the host network namespace.
+	NetworkModeHost = "host"
+
+	// NetworkModeBridge leaves networking to the driver, which for docker
+	// attaches the task to its bridge network. Nomad's CNI bridge is not
+	// set up.
+	NetworkModeBridge = "bridge"
+
+	// NetworkModeNone runs the task in a network namespace of its alloc
+	// with only a loopback interface.
+	NetworkModeNone = "none"
+)
+
+// networkIsolationModes maps the network modes to the isolation the driver
+// has to support for them.
+var networkIsolationModes = map[string]drivers.NetIsolationMode{
+	NetworkModeHost:   drivers.NetIsolationModeHost,
+	NetworkModeBridge: drivers.NetIsolationModeTask,
+	NetworkModeNone:   drivers.NetIsolationModeGroup,
+}
+
+// SetupNetwork prepares the network of t for mode, one of the NetworkMode
+// constants, before it is started. The driver capabilities must include the
+// isolation mode needs. For NetworkModeNone a network namespace is created
+// for the alloc of t, by the driver if it must initiate the network and by
+// the harness otherwise, and passed to the task as t.NetworkIsolation. The
+// returned cleanup destroys the namespace and must be called once the task
+// exited and was destroyed.
+func (h *DriverHarness) SetupNetwork(t *drivers.TaskConfig, mode string) (func(), error) {
+	isolation, ok := networkIsolationModes[mode]
+	if !ok {
+		return nil, fmt.Errorf("unknown network mode %q, expected host, bridge or none", mode)
+	}
+
+	caps, err := h.Capabilities()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get driver capabilities: %v", err)
+	}
+	if !caps.HasNetIsolationMode(isolation) {
+		return nil, fmt.Errorf("driver %s does not support %s network isolation needed for network mode %s", h.driverName, isolation, mode)
+	}
+	if isolation != drivers.NetIsolationModeGroup {
+		return func() {}, nil
+	}
+
+	var (
+		spec    *drivers.NetworkIsolationSpec
+		destroy func() error
+	)
+	if caps.MustInitiateNetwork {
+		if h.netManager == nil {
+			return nil, fmt.Errorf("driver %s must create the network but does not implement network management", h.driverName)
+		}
+		spec, _, err = h.netManager.CreateNetwork(t.AllocID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create network: %v", err)
+		}
+		destroy = func() error { return h.netManager.DestroyNetwork(t.AllocID, spec) }
+	} else {
+		spec, err = createNetNS(t.AllocID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create network namespace: %v", err)
+		}
+		destroy = func() error { return destroyNetNS(spec) }
+	}
+
+	t.NetworkIsolation = spec
+	h.logger.Info("created network", "alloc", t.AllocID, "mode", mode, "path", spec.Path)
+	return func() {
+		if err := destroy(); err != nil {
+			h.logger.Error("failed to destroy network", "alloc", t.AllocID, "path", spec.Path, "error", err)
+		}
+	}, nil
+}
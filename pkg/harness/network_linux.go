@@ -0,0 +1,25 @@
+package harness
+
+import (
+	"github.com/hashicorp/nomad/client/lib/nsutil"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// createNetNS creates a network namespace for allocID the way the Nomad
+// client does for drivers which do not create their own. It needs root.
+func createNetNS(allocID string) (*drivers.NetworkIsolationSpec, error) {
+	netns, err := nsutil.NewNS(allocID)
+	if err != nil {
+		return nil, err
+	}
+	return &drivers.NetworkIsolationSpec{
+		Mode:   drivers.NetIsolationModeGroup,
+		Path:   netns.Path(),
+		Labels: make(map[string]string),
+	}, nil
+}
+
+// destroyNetNS removes the network namespace createNetNS created.
+func destroyNetNS(spec *drivers.NetworkIsolationSpec) error {
+	return nsutil.UnmountNS(spec.Path)
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package harness
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// createNetNS fails, network namespaces only exist on Linux.
+func createNetNS(string) (*drivers.NetworkIsolationSpec, error) {
+	return nil, fmt.Errorf("network namespaces are not supported on %s", runtime.GOOS)
+}
+
+func destroyNetNS(*drivers.NetworkIsolationSpec) error {
+	return nil
+}
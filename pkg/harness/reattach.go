@@ -0,0 +1,43 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	plugin "github.com/hashicorp/go-plugin"
+	pstructs "github.com/hashicorp/nomad/plugins/shared/structs"
+)
+
+// LoadReattachConfig reads the JSON reattach config of a running plugin in
+// path. A missing file, or an empty path, returns nil so the plugin can be
+// launched instead.
+func LoadReattachConfig(path string) (*plugin.ReattachConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rc pstructs.ReattachConfig
+	if err := json.Unmarshal(b, &rc); err != nil {
+		return nil, fmt.Errorf("failed to decode reattach config %s: %v", path, err)
+	}
+	// go-plugin falls back to net/rpc without a protocol
+	if rc.Protocol == "" {
+		rc.Protocol = string(plugin.ProtocolGRPC)
+	}
+
+	reattach, err := pstructs.ReattachConfigToGoPlugin(&rc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reattach config %s: %v", path, err)
+	}
+	return reattach, nil
+}
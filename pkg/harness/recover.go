@@ -0,0 +1,78 @@
+package harness
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// taskHandleFile is written to the alloc dir of a started task so a later
+// harness can re-attach to it.
+const taskHandleFile = "task_handle.json"
+
+// SaveTaskHandle writes handle to the alloc dir of its task and returns the
+// path of the file.
+func SaveTaskHandle(handle *drivers.TaskHandle) (string, error) {
+	if handle.Config == nil || handle.Config.AllocDir == "" {
+		return "", fmt.Errorf("task handle has no alloc dir")
+	}
+
+	b, err := json.MarshalIndent(handle, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(handle.Config.AllocDir, taskHandleFile)
+	if err := ioutil.WriteFile(path, b, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// LoadTaskHandle reads a task handle written by SaveTaskHandle.
+func LoadTaskHandle(path string) (*drivers.TaskHandle, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var handle drivers.TaskHandle
+	if err := json.Unmarshal(b, &handle); err != nil {
+		return nil, fmt.Errorf("failed to decode task handle %s: %v", path, err)
+	}
+	if handle.Config == nil || handle.Config.ID == "" {
+		return nil, fmt.Errorf("task handle %s has no task", path)
+	}
+	return &handle, nil
+}
+
+// RecoverTask re-attaches the driver to a task started by an earlier
+// harness. Drivers do not advertise recovery in their capabilities, so the
+// capabilities are only used to check the driver is answering and a handle
+// without driver state is rejected up front.
+func (h *DriverHarness) RecoverTask(handle *drivers.TaskHandle) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		return ErrClosed
+	}
+
+	caps, err := h.DriverPlugin.Capabilities()
+	if err != nil {
+		return fmt.Errorf("failed to query driver capabilities: %v", err)
+	}
+	h.logger.Debug("recovering task", "task_id", handle.Config.ID, "fs_isolation", caps.FSIsolation)
+
+	if len(handle.DriverState) == 0 {
+		return fmt.Errorf("task handle has no driver state to recover from")
+	}
+	if err := h.DriverPlugin.RecoverTask(handle); err != nil {
+		return err
+	}
+	h.trackTask(handle)
+	return nil
+}
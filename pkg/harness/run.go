@@ -0,0 +1,68 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// defaultStopTimeout is how long RunTask gives a task to exit after ctx is
+// done before it is killed.
+const defaultStopTimeout = 5 * time.Second
+
+// RunTask starts the task described by spec in a new alloc dir, waits for
+// it to exit and returns its exit result. If ctx is done first the task is
+// stopped with SIGINT, killed after spec.StopTimeout, and ctx.Err() returned
+// along with the exit result. The task and its alloc dir are destroyed
+// before returning, whether it started or not.
+func (h *DriverHarness) RunTask(ctx context.Context, spec TaskSpec) (*drivers.ExitResult, error) {
+	if spec.StopTimeout <= 0 {
+		spec.StopTimeout = defaultStopTimeout
+	}
+	task, taskCfg, err := h.NewTask(spec)
+	if err != nil {
+		return nil, err
+	}
+	h.EncodeTaskConfig(task, taskCfg)
+
+	ad, err := h.BuildAllocDir(task, true, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer ad.Destroy()
+
+	if _, _, err := h.StartTask(task); err != nil {
+		ad.StopLogs()
+		return nil, fmt.Errorf("failed to start task: %v", err)
+	}
+
+	result, err := h.WaitTask(ctx, task.ID)
+	if ctx.Err() != nil {
+		result, err = h.stopRun(task.ID, spec.StopTimeout)
+		if err == nil {
+			err = ctx.Err()
+		}
+	}
+
+	// As in RunAndCapture, logmon is stopped after the task so the last
+	// output is flushed.
+	if derr := h.DestroyTask(task.ID, true); derr != nil && err == nil {
+		err = fmt.Errorf("failed to destroy task: %v", derr)
+	}
+	ad.StopLogs()
+	return result, err
+}
+
+// stopRun stops a task RunTask is waiting on and returns its exit result.
+func (h *DriverHarness) stopRun(taskID string, timeout time.Duration) (*drivers.ExitResult, error) {
+	if err := h.StopTask(taskID, timeout, "SIGINT"); err != nil {
+		return nil, fmt.Errorf("failed to stop task: %v", err)
+	}
+
+	// The driver kills the task once timeout passes, allow for that too.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+defaultStopTimeout)
+	defer cancel()
+	return h.WaitTask(ctx, taskID)
+}
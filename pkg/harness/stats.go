@@ -0,0 +1,54 @@
+package harness
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// StreamStats streams the resource usage of a task every interval until ctx
+// is done. The returned channel is closed when the stream ends. Drivers which
+// cannot report stats for the task return an error here.
+func (h *DriverHarness) StreamStats(ctx context.Context, taskID string, interval time.Duration) (<-chan *drivers.TaskResourceUsage, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("stats interval must be positive, got %s", interval)
+	}
+
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	spanCtx, span := h.startSpan(ctx, "TaskStats", taskID)
+	in, err := h.DriverPlugin.TaskStats(spanCtx, taskID, interval)
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream task stats: %v", err)
+	}
+
+	out := make(chan *drivers.TaskResourceUsage)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case u, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case out <- u:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
@@ -0,0 +1,199 @@
+package harness
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tailPollInterval is how often TailLogs checks the log files for new output.
+const tailPollInterval = 250 * time.Millisecond
+
+// TailLogs writes the task stdout and stderr to w as logmon writes them,
+// whole lines at a time, until ctx is done. Output already in the log files
+// is written first. logmon rotates to <base>.N+1 once <base>.N reaches
+// MaxFileSizeMB and purges the oldest files beyond MaxFiles; a tail which
+// fell behind a purge resumes at the oldest file left.
+func (a *TaskAllocDir) TailLogs(ctx context.Context, w io.Writer) error {
+	lw := &lockedWriter{w: w}
+
+	errCh := make(chan error, 2)
+	for _, base := range []string{a.stdoutFile, a.stderrFile} {
+		go func(base string) {
+			errCh <- a.FollowLog(ctx, lw, base)
+		}(base)
+	}
+
+	var err error
+	for i := 0; i < 2; i++ {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// FollowLog writes the output of a single log file of the task to w, whole
+// lines at a time, until ctx is done. base is StdoutFile or StderrFile, it is
+// followed across rotations as TailLogs does.
+func (a *TaskAllocDir) FollowLog(ctx context.Context, w io.Writer, base string) error {
+	t := &logTail{dir: a.taskDir.LogDir, base: base, idx: -1}
+	return t.follow(ctx, w)
+}
+
+// lockedWriter serialises whole line writes of both streams to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
+// logTail follows the rotated files <base>.N in dir. idx is the file being
+// read, -1 before the first one appeared, and offset how far into it.
+type logTail struct {
+	dir     string
+	base    string
+	idx     int
+	offset  int64
+	partial []byte
+}
+
+func (t *logTail) follow(ctx context.Context, w io.Writer) error {
+	for {
+		if err := t.poll(w); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			// Catch up once more and flush a last line the task did not
+			// terminate.
+			if err := t.poll(w); err != nil {
+				return err
+			}
+			if len(t.partial) > 0 {
+				if _, err := w.Write(append(t.partial, '\n')); err != nil {
+					return err
+				}
+			}
+			return nil
+		case <-time.After(tailPollInterval):
+		}
+	}
+}
+
+// poll writes the lines added since the last poll, moving on to newer files
+// as logmon rotates.
+func (t *logTail) poll(w io.Writer) error {
+	idxs, err := logFileIndexes(t.dir, t.base)
+	if err != nil {
+		return err
+	}
+	if len(idxs) == 0 {
+		return nil
+	}
+
+	// The file being read was purged, or this is the first poll.
+	if t.idx < idxs[0] {
+		if t.idx >= 0 {
+			t.partial = nil
+		}
+		t.idx, t.offset = idxs[0], 0
+	}
+
+	for {
+		if err := t.readCurrent(w); err != nil {
+			return err
+		}
+
+		next := -1
+		for _, idx := range idxs {
+			if idx > t.idx {
+				next = idx
+				break
+			}
+		}
+		if next < 0 {
+			return nil
+		}
+
+		// logmon had moved on to a newer file before idxs was listed, so
+		// the current one was complete when it was read.
+		t.idx, t.offset = next, 0
+	}
+}
+
+// readCurrent writes the complete lines past offset in the current file.
+func (t *logTail) readCurrent(w io.Writer) error {
+	f, err := os.Open(filepath.Join(t.dir, fmt.Sprintf("%s.%d", t.base, t.idx)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// Truncated in place, start over.
+	if fi.Size() < t.offset {
+		t.offset, t.partial = 0, nil
+	}
+
+	if _, err := f.Seek(t.offset, io.SeekStart); err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	t.offset += int64(len(b))
+
+	data := append(t.partial, b...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		t.partial = data
+		return nil
+	}
+	t.partial = append([]byte(nil), data[end+1:]...)
+	_, err = w.Write(data[:end+1])
+	return err
+}
+
+// logFileIndexes returns the indexes N of the files <base>.N in dir, sorted.
+func logFileIndexes(dir, base string) ([]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var idxs []int
+	for _, e := range entries {
+		suffix := strings.TrimPrefix(e.Name(), base+".")
+		if suffix == e.Name() {
+			continue
+		}
+		if idx, err := strconv.Atoi(suffix); err == nil {
+			idxs = append(idxs, idx)
+		}
+	}
+	sort.Ints(idxs)
+	return idxs, nil
+}
@@ -0,0 +1,115 @@
+package harness
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/helper/uuid"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// TaskSpec describes a task of the harness. Only Command is required, the
+// other fields default to the harness task config and resources. It is also
+// the JSON body the CLI's -serve API starts tasks from.
+type TaskSpec struct {
+	// Name of the task, "task" if empty.
+	Name string `json:"name,omitempty"`
+
+	// ID and AllocID of the task, random if empty.
+	ID      string `json:"id,omitempty"`
+	AllocID string `json:"alloc_id,omitempty"`
+
+	// Image replaces the image of the harness task config, which is then
+	// pulled instead of loaded from a tar.
+	Image string `json:"image,omitempty"`
+
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+
+	// Resources replace the harness resources.
+	Resources *drivers.Resources `json:"resources,omitempty"`
+
+	// PortMap maps port labels to container ports, Mounts are added to the
+	// mounts of the harness task config.
+	PortMap map[string]int       `json:"port_map,omitempty"`
+	Mounts  []docker.DockerMount `json:"mounts,omitempty"`
+
+	// StopTimeout is how long RunTask gives the task to exit after ctx is
+	// done before it is killed, 5s if zero.
+	StopTimeout time.Duration `json:"stop_timeout,omitempty"`
+}
+
+// DefaultTaskSpec returns a task running a busybox command which runs
+// indefinitely, and ideally responds to SIGINT/SIGTERM. Sadly, busybox:1.29.3
+// /bin/sleep doesn't, so it is an nc listener. Image and Resources are left to
+// the harness, DefaultTaskConfig and DefaultResources unless set otherwise.
+func DefaultTaskSpec() TaskSpec {
+	return TaskSpec{
+		Name:    "nc-demo",
+		Command: "nc",
+		Args:    []string{"-l", "-p", "3000", "127.0.0.1"},
+	}
+}
+
+// NewTask returns the task and docker task config described by spec. The
+// task config is not encoded into the task yet, see EncodeTaskConfig.
+func (h *DriverHarness) NewTask(spec TaskSpec) (*drivers.TaskConfig, docker.TaskConfig, error) {
+	if spec.Command == "" {
+		return nil, docker.TaskConfig{}, fmt.Errorf("task spec has no command")
+	}
+	if spec.Name == "" {
+		spec.Name = "task"
+	}
+	if spec.Resources == nil {
+		spec.Resources = h.Resources()
+	}
+
+	taskCfg := h.TaskConfig(append([]string{spec.Command}, spec.Args...))
+	spec.ApplyTo(&taskCfg)
+
+	task := &drivers.TaskConfig{
+		ID:        spec.ID,
+		Name:      spec.Name,
+		AllocID:   spec.AllocID,
+		Resources: spec.Resources,
+	}
+	if task.ID == "" {
+		task.ID = uuid.Generate()
+	}
+	if task.AllocID == "" {
+		task.AllocID = uuid.Generate()
+	}
+	// Copied so the Nomad variables MkAllocDir adds do not end up in spec.
+	if len(spec.Env) > 0 {
+		task.Env = make(map[string]string, len(spec.Env))
+		for k, v := range spec.Env {
+			task.Env[k] = v
+		}
+	}
+	return task, taskCfg, nil
+}
+
+// ApplyTo sets the command, image, port map and mounts of spec in cfg, for
+// task configs which do not come from the harness, e.g. read from a file.
+// Empty fields leave cfg as it is.
+func (s TaskSpec) ApplyTo(cfg *docker.TaskConfig) {
+	if s.Command != "" {
+		cfg.Command, cfg.Args = s.Command, s.Args
+	}
+	if s.Image != "" {
+		cfg.Image, cfg.LoadImage = s.Image, ""
+	}
+	if len(s.PortMap) > 0 {
+		portMap := make(map[string]int, len(cfg.PortMap)+len(s.PortMap))
+		for label, port := range cfg.PortMap {
+			portMap[label] = port
+		}
+		for label, port := range s.PortMap {
+			portMap[label] = port
+		}
+		cfg.PortMap = portMap
+	}
+	cfg.Mounts = append(cfg.Mounts, s.Mounts...)
+}
@@ -0,0 +1,146 @@
+package harness
+
+import (
+	"context"
+	"path"
+	"sync"
+
+	"github.com/hashicorp/nomad/plugins/drivers/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// tracerName is the instrumentation name of the harness spans.
+const tracerName = "github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+
+// WithTracerProvider records the spans of the driver calls with tp instead
+// of the global otel tracer provider, which does nothing unless one is set.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(h *DriverHarness) {
+		h.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// initTracing sets the tracer unless WithTracerProvider did and looks up the
+// driver name recorded on every span.
+func (h *DriverHarness) initTracing() {
+	if h.tracer == nil {
+		h.tracer = otel.Tracer(tracerName)
+	}
+	if info, err := h.impl.PluginInfo(); err == nil {
+		h.driverName = info.Name
+	}
+}
+
+// startSpan starts the span of a driver call on taskID as a child of ctx.
+func (h *DriverHarness) startSpan(ctx context.Context, name, taskID string) (context.Context, trace.Span) {
+	return h.tracer.Start(ctx, name, trace.WithAttributes(
+		attribute.String("task.id", taskID),
+		attribute.String("driver.name", h.driverName),
+	))
+}
+
+// endSpan ends span, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// callSpans holds the span contexts of the StartTask and StopTask calls in
+// flight by callKey. The driver client makes their gRPC calls with a context
+// of its own, so the interceptors look the span up by the task ID of the
+// request instead.
+var callSpans sync.Map
+
+// callKey is a driver call on a task, method is the gRPC method name.
+type callKey struct {
+	method string
+	taskID string
+}
+
+// trackCall makes the span in ctx the parent of the gRPC call method on
+// taskID until the returned func is called.
+func trackCall(ctx context.Context, method, taskID string) func() {
+	key := callKey{method, taskID}
+	callSpans.Store(key, trace.SpanContextFromContext(ctx))
+	return func() { callSpans.Delete(key) }
+}
+
+// tracingDialOptions returns the interceptors passing the trace context of
+// the driver calls on to the plugin in the gRPC metadata. It is injected with
+// the global otel propagator, which injects nothing unless one is set.
+func tracingDialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(traceUnaryInterceptor),
+		grpc.WithChainStreamInterceptor(traceStreamInterceptor),
+	}
+}
+
+func traceUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(injectTrace(ctx, method, req), method, req, reply, cc, opts...)
+}
+
+func traceStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	// Streams are only opened with the context of the call, as TaskStats is.
+	return streamer(injectTrace(ctx, method, nil), desc, cc, method, opts...)
+}
+
+// injectTrace adds the span of the driver call making the gRPC call method
+// with req to the outgoing metadata of ctx.
+func injectTrace(ctx context.Context, method string, req interface{}) context.Context {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		if v, ok := callSpans.Load(callKey{path.Base(method), requestTaskID(req)}); ok {
+			sc = v.(trace.SpanContext)
+		}
+	}
+	if !sc.IsValid() {
+		return ctx
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	otel.GetTextMapPropagator().Inject(trace.ContextWithSpanContext(ctx, sc), metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// requestTaskID returns the ID of the task a driver request is for, empty
+// for requests not on a task.
+func requestTaskID(req interface{}) string {
+	switch r := req.(type) {
+	case *proto.StartTaskRequest:
+		return r.GetTask().GetId()
+	case interface{ GetTaskId() string }:
+		return r.GetTaskId()
+	}
+	return ""
+}
+
+// metadataCarrier is a propagation.TextMapCarrier over gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
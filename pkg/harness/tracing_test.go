@@ -0,0 +1,153 @@
+package harness
+
+import (
+	"context"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// traceparentRecorder keeps the traceparent each gRPC method was last
+// called with.
+type traceparentRecorder struct {
+	mu      sync.Mutex
+	parents map[string]string
+}
+
+func (r *traceparentRecorder) record(ctx context.Context, method string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v := md.Get("traceparent"); len(v) > 0 {
+		r.parents[path.Base(method)] = v[0]
+	}
+}
+
+func (r *traceparentRecorder) get(method string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.parents[method]
+}
+
+// newGRPCHarness serves a mock driver over gRPC and returns a harness
+// talking to it through a connection with the tracing interceptors.
+func newGRPCHarness(t *testing.T, tp *sdktrace.TracerProvider) (*DriverHarness, *traceparentRecorder) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	logger := hclog.NewNullLogger()
+
+	rec := &traceparentRecorder{parents: make(map[string]string)}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			rec.record(ctx, info.FullMethod)
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			rec.record(ss.Context(), info.FullMethod)
+			return handler(srv, ss)
+		}),
+	)
+	impl := mockdriver.NewWithConfig(ctx, logger, mockdriver.Config{RunFor: time.Hour})
+	if err := drivers.NewDriverPlugin(impl, logger).GRPCServer(nil, srv); err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(ln.Addr().String(), append(tracingDialOptions(), grpc.WithInsecure())...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client, err := drivers.NewDriverPlugin(impl, logger).GRPCClient(ctx, nil, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dh := NewInProcess(logger, client.(drivers.DriverPlugin), WithTracerProvider(tp))
+	t.Cleanup(dh.Close)
+	return dh, rec
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	prev := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(prev)
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	dh, rec := newGRPCHarness(t, tp)
+	task := newMockTask(t, dh)
+
+	if _, _, err := dh.StartTask(task); err != nil {
+		t.Fatalf("failed to start task: %v", err)
+	}
+	stats, err := dh.StreamStats(context.Background(), task.ID, time.Second)
+	if err != nil {
+		t.Fatalf("failed to stream stats: %v", err)
+	}
+	<-stats
+	if _, err := dh.InspectTask(task.ID); err != nil {
+		t.Fatalf("failed to inspect task: %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	exitCh := make(chan error, 1)
+	go func() {
+		_, err := dh.WaitTask(waitCtx, task.ID)
+		exitCh <- err
+	}()
+	if err := dh.StopTask(task.ID, time.Second, "SIGINT"); err != nil {
+		t.Fatalf("failed to stop task: %v", err)
+	}
+	if err := <-exitCh; err != nil {
+		t.Fatalf("failed to wait on task: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	for _, method := range []string{"StartTask", "StopTask", "WaitTask", "TaskStats"} {
+		span, ok := spans[method]
+		if !ok {
+			t.Errorf("no %s span recorded", method)
+			continue
+		}
+		sc := span.SpanContext()
+		// version-traceid-spanid-flags
+		want := strings.Join([]string{"00", sc.TraceID().String(), sc.SpanID().String(), "01"}, "-")
+		if got := rec.get(method); got != want {
+			t.Errorf("%s called with traceparent %q, expected %q", method, got, want)
+		}
+	}
+
+	// Calls outside a traced driver call carry no trace context.
+	if got := rec.get("InspectTask"); got != "" {
+		t.Errorf("InspectTask called with traceparent %q", got)
+	}
+	callSpans.Range(func(k, _ interface{}) bool {
+		t.Errorf("span of call %+v left behind", k)
+		return true
+	})
+}
@@ -0,0 +1,147 @@
+package harness
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hcldec"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclspecutils"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// durationFields are the docker task config fields the driver parses as
+// durations only once the task is starting, while the schema types them as
+// plain strings.
+var durationFields = []string{"image_pull_timeout"}
+
+// ValidateTaskConfig checks the driver config of t against the task config
+// schema of the driver, the way Nomad checks the config block of a job, and
+// reports unknown fields, values of the wrong type and missing required
+// fields. StartTask calls it before starting the task.
+//
+// The driver config is encoded from a Go struct, so fields left at their zero
+// value count as not set.
+func (h *DriverHarness) ValidateTaskConfig(t *drivers.TaskConfig) error {
+	spec, err := h.taskConfigSpec()
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]interface{}
+	if err := t.DecodeDriverConfig(&raw); err != nil {
+		return fmt.Errorf("failed to decode task config: %v", err)
+	}
+	set, _ := withoutZeroValues(raw).(map[string]interface{})
+
+	var msgs []string
+	if _, diags, errs := hclutils.ParseHclInterface(set, spec, nil); diags.HasErrors() {
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	for _, field := range durationFields {
+		v, ok := set[field].(string)
+		if !ok {
+			continue
+		}
+		if _, err := time.ParseDuration(v); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: invalid duration %q", field, v))
+		}
+	}
+
+	if len(msgs) > 0 {
+		return fmt.Errorf("invalid task config for task %s: %s", t.Name, strings.Join(msgs, "; "))
+	}
+	return nil
+}
+
+// taskConfigSpec returns the task config schema of the driver, fetched once.
+func (h *DriverHarness) taskConfigSpec() (hcldec.Spec, error) {
+	h.specMu.Lock()
+	defer h.specMu.Unlock()
+
+	if h.taskSpec != nil {
+		return h.taskSpec, nil
+	}
+
+	schema, err := h.DriverPlugin.TaskConfigSchema()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get task config schema: %v", err)
+	}
+	spec, diags := hclspecutils.Convert(schema)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("invalid task config schema: %v", diags)
+	}
+
+	h.taskSpec = spec
+	return spec, nil
+}
+
+// withoutZeroValues returns v with the zero values in it removed, or nil if
+// nothing is left. Maps and lists end up nil once empty.
+func withoutZeroValues(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if e = withoutZeroValues(e); e != nil {
+				out[k] = e
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if e = withoutZeroValues(e); e != nil {
+				out[fmt.Sprint(k)] = e
+			}
+		}
+		if len(out) == 0 {
+			return nil
+		}
+		return out
+	case []interface{}:
+		if len(v) == 0 {
+			return nil
+		}
+		// Only blocks in lists are pruned, an empty string in a list of
+		// strings is still set.
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = e
+			switch e.(type) {
+			case map[string]interface{}, map[interface{}]interface{}:
+				if out[i] = withoutZeroValues(e); out[i] == nil {
+					out[i] = map[string]interface{}{}
+				}
+			}
+		}
+		return out
+	case string:
+		if v == "" {
+			return nil
+		}
+	case bool:
+		if !v {
+			return nil
+		}
+	case int64:
+		if v == 0 {
+			return nil
+		}
+	case uint64:
+		if v == 0 {
+			return nil
+		}
+	case float64:
+		if v == 0 {
+			return nil
+		}
+	}
+	return v
+}
@@ -0,0 +1,63 @@
+package harness
+
+import (
+	"testing"
+
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+func TestValidateTaskConfigImagePullTimeout(t *testing.T) {
+	cases := []struct {
+		name    string
+		timeout string
+		// err is the rejection error, empty for valid configs.
+		err string
+	}{
+		{
+			name:    "valid",
+			timeout: "90s",
+		},
+		{
+			name:    "not set",
+			timeout: "",
+		},
+		{
+			name:    "invalid",
+			timeout: "five minutes",
+			err:     `invalid task config for task nc-demo: image_pull_timeout: invalid duration "five minutes"`,
+		},
+		{
+			name:    "missing unit",
+			timeout: "300",
+			err:     `invalid task config for task nc-demo: image_pull_timeout: invalid duration "300"`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			// The mock driver validates against the docker task config schema.
+			dh := newMockHarness(t, mockdriver.Config{})
+			task, taskCfg, err := dh.NewTask(DefaultTaskSpec())
+			if err != nil {
+				t.Fatal(err)
+			}
+			taskCfg.ImagePullTimeout = c.timeout
+			dh.EncodeTaskConfig(task, taskCfg)
+
+			err = dh.ValidateTaskConfig(task)
+			switch {
+			case c.err == "" && err != nil:
+				t.Fatalf("unexpected validation error: %v", err)
+			case c.err != "" && err == nil:
+				t.Fatal("invalid image_pull_timeout was accepted")
+			case c.err != "" && err.Error() != c.err:
+				t.Fatalf("validation error %q, expected %q", err, c.err)
+			}
+
+			// StartTask must refuse the task the same way.
+			if _, _, err := dh.StartTask(task); (err != nil) != (c.err != "") {
+				t.Fatalf("StartTask returned %v", err)
+			}
+		})
+	}
+}
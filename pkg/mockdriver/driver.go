@@ -0,0 +1,446 @@
+// Package mockdriver is an in-memory Nomad task driver for exercising the
+// harness without docker. Tasks do not run anything, they are marked running
+// on start and exit with a fixed result once their run time is up or they are
+// stopped.
+package mockdriver
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	cstructs "github.com/hashicorp/nomad/client/structs"
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+)
+
+const (
+	// PluginName is the name the mock driver reports.
+	PluginName = "mock"
+
+	// taskHandleVersion is the version of the driver state in task handles.
+	taskHandleVersion = 1
+)
+
+var (
+	pluginInfo = &base.PluginInfoResponse{
+		Type:              base.PluginTypeDriver,
+		PluginApiVersions: []string{drivers.ApiVersion010},
+		PluginVersion:     "0.1.0",
+		Name:              PluginName,
+	}
+
+	capabilities = &drivers.Capabilities{
+		SendSignals: true,
+		Exec:        false,
+		FSIsolation: drivers.FSIsolationNone,
+	}
+)
+
+// Config sets how mock tasks behave.
+type Config struct {
+	// RunFor is how long a task runs before it exits with ExitResult. Zero
+	// exits the task as soon as it is started, which keeps tests
+	// deterministic.
+	RunFor time.Duration
+
+	// ExitResult is the result a task exits with when its run time is up.
+	// Stopped tasks exit with the stop signal instead.
+	ExitResult *drivers.ExitResult
+}
+
+// taskState is the driver state stored in task handles.
+type taskState struct {
+	StartedAt time.Time
+}
+
+// task is a started mock task. exited is closed once result is set.
+type task struct {
+	cfg         *drivers.TaskConfig
+	startedAt   time.Time
+	completedAt time.Time
+	result      *drivers.ExitResult
+	exited      chan struct{}
+	timer       *time.Timer
+}
+
+// Driver is the mock driver. It is safe for concurrent use.
+type Driver struct {
+	ctx    context.Context
+	logger hclog.Logger
+	config Config
+
+	// taskConfigSpec is the task config schema of the docker driver.
+	taskConfigSpec *hclspec.Spec
+
+	mu     sync.Mutex
+	tasks  map[string]*task
+	events []chan *drivers.TaskEvent
+}
+
+// New returns a mock driver whose tasks exit with code 0 as soon as they are
+// started. It matches the docker driver constructor so the harness can select
+// it by name.
+func New(ctx context.Context, logger hclog.Logger) drivers.DriverPlugin {
+	return NewWithConfig(ctx, logger, Config{})
+}
+
+// NewWithConfig returns a mock driver whose tasks behave as set in cfg.
+func NewWithConfig(ctx context.Context, logger hclog.Logger, cfg Config) *Driver {
+	if cfg.ExitResult == nil {
+		cfg.ExitResult = &drivers.ExitResult{}
+	}
+	// The docker driver returns its static schema and never fails to.
+	spec, _ := docker.NewDockerDriver(ctx, logger).TaskConfigSchema()
+	return &Driver{
+		ctx:            ctx,
+		logger:         logger.Named(PluginName),
+		config:         cfg,
+		taskConfigSpec: spec,
+		tasks:          make(map[string]*task),
+	}
+}
+
+func (d *Driver) PluginInfo() (*base.PluginInfoResponse, error) {
+	return pluginInfo, nil
+}
+
+// ConfigSchema returns no schema, the mock driver has no plugin config.
+func (d *Driver) ConfigSchema() (*hclspec.Spec, error) {
+	return nil, nil
+}
+
+// SetConfig accepts and ignores any plugin config.
+func (d *Driver) SetConfig(*base.Config) error {
+	return nil
+}
+
+// TaskConfigSchema returns the docker task config schema, so the docker task
+// configs of the harness validate. Their fields are ignored.
+func (d *Driver) TaskConfigSchema() (*hclspec.Spec, error) {
+	return d.taskConfigSpec, nil
+}
+
+func (d *Driver) Capabilities() (*drivers.Capabilities, error) {
+	return capabilities, nil
+}
+
+// Fingerprint reports the driver healthy once and keeps the channel open
+// until ctx is done.
+func (d *Driver) Fingerprint(ctx context.Context) (<-chan *drivers.Fingerprint, error) {
+	ch := make(chan *drivers.Fingerprint, 1)
+	ch <- &drivers.Fingerprint{
+		Health:            drivers.HealthStateHealthy,
+		HealthDescription: drivers.DriverHealthy,
+	}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// RecoverTask restores a task from its handle. Tasks are only kept in memory,
+// so a task unknown to this driver is started again with the rest of its run
+// time.
+func (d *Driver) RecoverTask(handle *drivers.TaskHandle) error {
+	if handle == nil || handle.Config == nil {
+		return fmt.Errorf("task handle has no task config")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.tasks[handle.Config.ID]; ok {
+		return nil
+	}
+
+	var state taskState
+	if err := handle.GetDriverState(&state); err != nil {
+		return fmt.Errorf("failed to decode driver state: %v", err)
+	}
+
+	d.startLocked(handle.Config, state.StartedAt)
+	return nil
+}
+
+func (d *Driver) StartTask(cfg *drivers.TaskConfig) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.tasks[cfg.ID]; ok {
+		return nil, nil, fmt.Errorf("task with ID %q already started", cfg.ID)
+	}
+
+	t := d.startLocked(cfg, time.Now())
+
+	handle := drivers.NewTaskHandle(taskHandleVersion)
+	handle.Config = cfg
+	handle.State = drivers.TaskStateRunning
+	if err := handle.SetDriverState(&taskState{StartedAt: t.startedAt}); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode driver state: %v", err)
+	}
+
+	d.emitLocked(cfg, "Started mock task")
+	return handle, nil, nil
+}
+
+// startLocked records a running task started at startedAt and schedules its
+// exit. d.mu must be held.
+func (d *Driver) startLocked(cfg *drivers.TaskConfig, startedAt time.Time) *task {
+	t := &task{
+		cfg:       cfg,
+		startedAt: startedAt,
+		exited:    make(chan struct{}),
+	}
+	d.tasks[cfg.ID] = t
+
+	remaining := d.config.RunFor - time.Since(startedAt)
+	if d.config.RunFor == 0 || remaining <= 0 {
+		d.exitLocked(t, d.config.ExitResult.Copy())
+		return t
+	}
+
+	t.timer = time.AfterFunc(remaining, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.exitLocked(t, d.config.ExitResult.Copy())
+	})
+	return t
+}
+
+// exitLocked marks t exited with res unless it already exited. d.mu must be
+// held.
+func (d *Driver) exitLocked(t *task, res *drivers.ExitResult) {
+	if t.result != nil {
+		return
+	}
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.result = res
+	t.completedAt = time.Now()
+	close(t.exited)
+	closeLogPipes(t.cfg)
+	d.emitLocked(t.cfg, fmt.Sprintf("Exited with exit code %d", res.ExitCode))
+}
+
+// logPipeWait is how long closeLogPipes waits for logmon to open a log fifo.
+const logPipeWait = time.Second
+
+// closeLogPipes opens and closes the write side of the task's log fifos in the
+// background, as a real task does when it exits. Mock tasks write nothing, but
+// without a writer logmon waits for one when it is stopped until it gives up.
+// Opening would block until logmon reads, so it is retried without blocking
+// for up to logPipeWait instead.
+func closeLogPipes(cfg *drivers.TaskConfig) {
+	for _, path := range []string{cfg.StdoutPath, cfg.StderrPath} {
+		if path == "" {
+			continue
+		}
+		go func(path string) {
+			for deadline := time.Now().Add(logPipeWait); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				if f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+					f.Close()
+					return
+				}
+			}
+		}(path)
+	}
+}
+
+func (d *Driver) WaitTask(ctx context.Context, taskID string) (<-chan *drivers.ExitResult, error) {
+	d.mu.Lock()
+	t, ok := d.tasks[taskID]
+	d.mu.Unlock()
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	ch := make(chan *drivers.ExitResult, 1)
+	go func() {
+		defer close(ch)
+		select {
+		case <-t.exited:
+			d.mu.Lock()
+			ch <- t.result.Copy()
+			d.mu.Unlock()
+		case <-ctx.Done():
+		case <-d.ctx.Done():
+		}
+	}()
+	return ch, nil
+}
+
+// StopTask exits a running task with signal. The timeout is not waited for,
+// mock tasks exit immediately.
+func (d *Driver) StopTask(taskID string, timeout time.Duration, signal string) error {
+	return d.SignalTask(taskID, signal)
+}
+
+func (d *Driver) DestroyTask(taskID string, force bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tasks[taskID]
+	if !ok {
+		return drivers.ErrTaskNotFound
+	}
+	if t.result == nil && !force {
+		return fmt.Errorf("cannot destroy running task")
+	}
+
+	d.exitLocked(t, &drivers.ExitResult{Signal: signalNumber("SIGKILL")})
+	delete(d.tasks, taskID)
+	return nil
+}
+
+func (d *Driver) InspectTask(taskID string) (*drivers.TaskStatus, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tasks[taskID]
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	status := &drivers.TaskStatus{
+		ID:        t.cfg.ID,
+		Name:      t.cfg.Name,
+		State:     drivers.TaskStateRunning,
+		StartedAt: t.startedAt,
+	}
+	if t.result != nil {
+		status.State = drivers.TaskStateExited
+		status.CompletedAt = t.completedAt
+		status.ExitResult = t.result.Copy()
+	}
+	return status, nil
+}
+
+// TaskStats reports zero usage every interval until ctx is done or the task
+// exits.
+func (d *Driver) TaskStats(ctx context.Context, taskID string, interval time.Duration) (<-chan *cstructs.TaskResourceUsage, error) {
+	d.mu.Lock()
+	t, ok := d.tasks[taskID]
+	d.mu.Unlock()
+	if !ok {
+		return nil, drivers.ErrTaskNotFound
+	}
+
+	ch := make(chan *cstructs.TaskResourceUsage)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				usage := &cstructs.TaskResourceUsage{
+					ResourceUsage: &cstructs.ResourceUsage{
+						MemoryStats: &cstructs.MemoryStats{},
+						CpuStats:    &cstructs.CpuStats{},
+					},
+					Timestamp: now.UnixNano(),
+				}
+				select {
+				case ch <- usage:
+				case <-ctx.Done():
+					return
+				}
+			case <-t.exited:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// TaskEvents streams a start and an exit event for every task. Events are
+// dropped for subscribers which fall behind.
+func (d *Driver) TaskEvents(ctx context.Context) (<-chan *drivers.TaskEvent, error) {
+	ch := make(chan *drivers.TaskEvent, 16)
+
+	d.mu.Lock()
+	d.events = append(d.events, ch)
+	d.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-d.ctx.Done():
+		}
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		for i, c := range d.events {
+			if c == ch {
+				d.events = append(d.events[:i], d.events[i+1:]...)
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// emitLocked sends an event for cfg to every subscriber. d.mu must be held.
+func (d *Driver) emitLocked(cfg *drivers.TaskConfig, msg string) {
+	ev := &drivers.TaskEvent{
+		TaskID:    cfg.ID,
+		TaskName:  cfg.Name,
+		AllocID:   cfg.AllocID,
+		Timestamp: time.Now(),
+		Message:   msg,
+	}
+	for _, ch := range d.events {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// SignalTask exits a running task as if it was killed by signal.
+func (d *Driver) SignalTask(taskID string, signal string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tasks[taskID]
+	if !ok {
+		return drivers.ErrTaskNotFound
+	}
+
+	d.exitLocked(t, &drivers.ExitResult{Signal: signalNumber(signal)})
+	return nil
+}
+
+// ExecTask is not supported, the mock driver runs no processes.
+func (d *Driver) ExecTask(taskID string, cmd []string, timeout time.Duration) (*drivers.ExecTaskResult, error) {
+	return nil, fmt.Errorf("mock driver does not support exec")
+}
+
+// signalNumber returns the number of a signal name, 0 for unknown names.
+func signalNumber(name string) int {
+	switch name {
+	case "SIGHUP":
+		return 1
+	case "SIGINT":
+		return 2
+	case "SIGQUIT":
+		return 3
+	case "SIGKILL":
+		return 9
+	case "SIGTERM":
+		return 15
+	}
+	return 0
+}
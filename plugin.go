@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	plugin "github.com/hashicorp/go-plugin"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// pluginReattachFile is a JSON file holding the reattach config of an already
+// running plugin, set with -plugin-reattach. When it exists the harness
+// connects to that plugin instead of launching pluginPath.
+var pluginReattachFile string
+
+// pluginStartupTimeout bounds the plugin handshake and dispense, set with
+// -startup-timeout.
+var pluginStartupTimeout = 30 * time.Second
+
+// connectRetries is how often connecting to the plugin is retried after a
+// failure, set with -connect-retries.
+var connectRetries = 2
+
+// connectBackoff is the wait before the first connect retry, doubled after
+// every further one, set with -connect-backoff.
+var connectBackoff = time.Second
+
+// grpcKeepalive is how often an idle gRPC connection to the plugin or a
+// logmon process is pinged, set with -grpc-keepalive. Zero sends no pings.
+var grpcKeepalive time.Duration
+
+// minGRPCKeepalive is the shortest ping interval gRPC servers accept by
+// default, the plugin closes connections pinging more often.
+const minGRPCKeepalive = 5 * time.Minute
+
+// grpcMaxMsgMB is the largest message in MiB received from the plugin or a
+// logmon process, set with -grpc-max-msg-mb. Zero keeps go-plugin's limit.
+var grpcMaxMsgMB int
+
+// pluginInProcess calls the driver directly instead of launching or
+// reattaching to a plugin, set for drivers in inProcessDrivers.
+var pluginInProcess bool
+
+// newHarness launches the plugin at pluginPath, or reattaches to the one in
+// pluginReattachFile, and returns a harness for its driver. A failed connect
+// is retried connectRetries times with exponential backoff, except for
+// protocol version mismatches, reported as a *handshakeError, and a binary
+// not matching pluginChecksum, reported as a *checksumError. In process
+// drivers skip the plugin altogether.
+func newHarness(logger hclog.Logger, impl drivers.DriverPlugin, opts ...harness.Option) (*harness.DriverHarness, error) {
+	dialOpts := grpcDialOptions()
+	opts = append([]harness.Option{harness.WithGRPCDialOptions(dialOpts...)}, opts...)
+	if pluginInProcess {
+		return harness.NewInProcess(logger, impl, opts...), nil
+	}
+
+	opts = append([]harness.Option{harness.WithStartupTimeout(pluginStartupTimeout)}, opts...)
+
+	reattach, err := harness.LoadReattachConfig(pluginReattachFile)
+	if err != nil {
+		return nil, err
+	}
+
+	backoff := connectBackoff
+	for attempt := 1; ; attempt++ {
+		dh, err := connectHarness(logger, impl, reattach, dialOpts, opts)
+		if err == nil {
+			return dh, nil
+		}
+
+		var he *handshakeError
+		var ce *checksumError
+		if errors.As(err, &he) || errors.As(err, &ce) {
+			return nil, err
+		}
+		if attempt > connectRetries {
+			if attempt > 1 {
+				err = fmt.Errorf("failed to connect to plugin after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+
+		logger.Warn("failed to connect to plugin, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// connectHarness makes one attempt of newHarness with a new plugin client,
+// reattaching to the plugin if reattach is set.
+func connectHarness(logger hclog.Logger, impl drivers.DriverPlugin, reattach *plugin.ReattachConfig, dialOpts []grpc.DialOption, opts []harness.Option) (*harness.DriverHarness, error) {
+	if reattach != nil {
+		client := harness.NewReattachClient(logger, impl, reattach, dialOpts...)
+		dh, err := harness.New(logger, client, impl, append(opts, harness.KeepPlugin())...)
+		if err != nil {
+			return nil, wrapHandshakeError(err)
+		}
+		logger.Info("reattached to plugin", "pid", reattach.Pid, "addr", reattach.Addr)
+		return dh, nil
+	}
+
+	client := harness.NewPluginClient(logger, impl, pluginPath, harness.PluginOptions{
+		AutoMTLS:        pluginAutoMTLS,
+		Stderr:          pluginStderr,
+		StartTimeout:    pluginStartupTimeout,
+		Checksum:        pluginChecksum,
+		GRPCDialOptions: dialOpts,
+	})
+	dh, err := harness.New(logger, client, impl, opts...)
+	if err != nil {
+		return nil, wrapChecksumError(wrapHandshakeError(err))
+	}
+	logger.Info("launched plugin", "path", pluginPath, "auto_mtls", pluginAutoMTLS)
+	return dh, nil
+}
+
+// grpcDialOptions returns the gRPC dial options set with -grpc-keepalive and
+// -grpc-max-msg-mb.
+func grpcDialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if grpcKeepalive > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time: grpcKeepalive,
+		}))
+	}
+	if grpcMaxMsgMB > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(grpcMaxMsgMB<<20)))
+	}
+	return opts
+}
+
+// pluginStderrSize is how much of the plugin stderr is kept for errors.
+const pluginStderrSize = 16 * 1024
+
+// pluginStderr keeps the end of the stderr of the launched plugin, so a
+// plugin which fails or crashes can be reported with its own output.
+var pluginStderr = &stderrTail{max: pluginStderrSize}
+
+// stderrTail is an io.Writer keeping the last max bytes written to it.
+type stderrTail struct {
+	mu  sync.Mutex
+	buf []byte
+	max int
+}
+
+func (t *stderrTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, starting at a line boundary.
+func (t *stderrTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := t.buf
+	if len(b) == t.max {
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			b = b[i+1:]
+		}
+	}
+	return string(bytes.TrimRight(b, "\n"))
+}
+
+// withPluginStderr adds the end of the plugin stderr to err, if there is any.
+func withPluginStderr(err error) error {
+	tail := pluginStderr.String()
+	if tail == "" {
+		return err
+	}
+	return fmt.Errorf("%v\nplugin stderr:\n%s", err, tail)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// portHostIP is the host address reserved ports are bound on.
+const portHostIP = "127.0.0.1"
+
+// portReservation is a labelled port reserved for the task. A zero Value
+// marks a dynamic port which is picked when the network is built.
+type portReservation struct {
+	Label string
+	Value int
+}
+
+// portFlags collects repeated -port-label flags of the form label[=port].
+type portFlags []portReservation
+
+func (p *portFlags) String() string {
+	parts := make([]string, 0, len(*p))
+	for _, r := range *p {
+		if r.Value == 0 {
+			parts = append(parts, r.Label)
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%d", r.Label, r.Value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *portFlags) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	label := kv[0]
+	if label == "" {
+		return fmt.Errorf("invalid port %q, label is required", v)
+	}
+	for _, r := range *p {
+		if r.Label == label {
+			return fmt.Errorf("port label %q given more than once", label)
+		}
+	}
+
+	r := portReservation{Label: label}
+	if len(kv) == 2 {
+		port, err := strconv.Atoi(kv[1])
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q for label %q", kv[1], label)
+		}
+		r.Value = port
+	}
+
+	*p = append(*p, r)
+	return nil
+}
+
+// networkResource returns the task network holding the reserved ports;
+// labels without a port get a free ephemeral port from the host.
+func (p portFlags) networkResource() (*structs.NetworkResource, error) {
+	nw := &structs.NetworkResource{
+		Mode: "host",
+		IP:   portHostIP,
+	}
+
+	for _, r := range p {
+		if r.Value != 0 {
+			nw.ReservedPorts = append(nw.ReservedPorts, structs.Port{Label: r.Label, Value: r.Value})
+			continue
+		}
+
+		port, err := freePort()
+		if err != nil {
+			return nil, fmt.Errorf("failed to allocate dynamic port %q: %v", r.Label, err)
+		}
+		nw.DynamicPorts = append(nw.DynamicPorts, structs.Port{Label: r.Label, Value: port})
+	}
+
+	return nw, nil
+}
+
+// freePort asks the kernel for an unused TCP port on portHostIP.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(portHostIP, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// portMapping maps a host port to a port inside the container.
+type portMapping struct {
+	Host      int
+	Container int
+}
+
+// label is the port label the mapping is reserved and mapped under.
+func (m portMapping) label() string {
+	return fmt.Sprintf("port_%d", m.Host)
+}
+
+// portMapFlags collects repeated -port flags of the form host:container.
+type portMapFlags []portMapping
+
+func (p *portMapFlags) String() string {
+	parts := make([]string, 0, len(*p))
+	for _, m := range *p {
+		parts = append(parts, fmt.Sprintf("%d:%d", m.Host, m.Container))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (p *portMapFlags) Set(v string) error {
+	kv := strings.SplitN(v, ":", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("invalid port %q, want host:container", v)
+	}
+
+	var ports [2]int
+	for i, s := range kv {
+		port, err := strconv.Atoi(s)
+		if err != nil || port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %q in %q", s, v)
+		}
+		ports[i] = port
+	}
+	for _, m := range *p {
+		if m.Host == ports[0] {
+			return fmt.Errorf("host port %d mapped more than once", m.Host)
+		}
+	}
+
+	*p = append(*p, portMapping{Host: ports[0], Container: ports[1]})
+	return nil
+}
+
+// reserve adds the host ports of the mappings to ports and returns the docker
+// port map sending each of them to its container port.
+func (p portMapFlags) reserve(ports *portFlags) (map[string]int, error) {
+	portMap := make(map[string]int, len(p))
+	for _, m := range p {
+		for _, r := range *ports {
+			if r.Label == m.label() || (r.Value != 0 && r.Value == m.Host) {
+				return nil, fmt.Errorf("host port %d is also given with -port-label", m.Host)
+			}
+		}
+		*ports = append(*ports, portReservation{Label: m.label(), Value: m.Host})
+		portMap[m.label()] = m.Container
+	}
+	return portMap, nil
+}
+
+// expandTaskEnv interpolates $VAR and ${VAR} references to the task
+// environment, leaving unknown variables untouched.
+func expandTaskEnv(args []string, env map[string]string) []string {
+	out := make([]string, len(args))
+	for i, arg := range args {
+		out[i] = os.Expand(arg, func(k string) string {
+			if v, ok := env[k]; ok {
+				return v
+			}
+			return "${" + k + "}"
+		})
+	}
+	return out
+}
+
+// verifyPortEnv checks the task sees the NOMAD_PORT_<label> variables of
+// its ports with the values the harness set in its environment.
+func verifyPortEnv(d drivers.DriverPlugin, taskID string, ports portFlags, env map[string]string) error {
+	res, err := d.ExecTask(taskID, []string{"/bin/sh", "-c", "env | grep NOMAD_PORT"}, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to read task environment: %v", err)
+	}
+
+	seen := make(map[string]string)
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) == 2 {
+			seen[kv[0]] = kv[1]
+		}
+	}
+	for _, p := range ports {
+		k := "NOMAD_PORT_" + p.Label
+		got, ok := seen[k]
+		if !ok {
+			return fmt.Errorf("%s not set in the task", k)
+		}
+		if got != env[k] {
+			return fmt.Errorf("%s is %s in the task, expected %s", k, got, env[k])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// preflightStatus is the outcome of a single preflight check.
+type preflightStatus string
+
+const (
+	preflightPass preflightStatus = "PASS"
+	preflightWarn preflightStatus = "WARN"
+	preflightFail preflightStatus = "FAIL"
+)
+
+// preflightResult is one row of the preflight report.
+type preflightResult struct {
+	Check   string
+	Status  preflightStatus
+	Message string
+}
+
+// runPreflight checks everything the harness needs before starting a task
+// loading loadImage, busybox.tar if empty. Checks which need a running plugin
+// are reported as failed if the plugin cannot be launched.
+func runPreflight(ctx context.Context, logger hclog.Logger, d drivers.DriverPlugin, cfg *base.Config, loadImage string) []preflightResult {
+	var results []preflightResult
+
+	results = append(results, checkPluginBinary())
+	results = append(results, checkImageTar(loadImage))
+	results = append(results, checkAllocDir())
+
+	if results[0].Status == preflightFail {
+		results = append(results,
+			preflightResult{"docker", preflightFail, "skipped, plugin binary unavailable"},
+			preflightResult{"capabilities", preflightFail, "skipped, plugin binary unavailable"},
+		)
+		return results
+	}
+
+	dh, err := newHarness(logger, d)
+	if err != nil {
+		msg := fmt.Sprintf("failed to dispense driver: %v", err)
+		return append(results,
+			preflightResult{"docker", preflightFail, msg},
+			preflightResult{"capabilities", preflightFail, msg},
+		)
+	}
+	defer dh.Close()
+
+	if err := dh.SetConfig(cfg); err != nil {
+		msg := fmt.Sprintf("failed to configure driver: %v", err)
+		return append(results,
+			preflightResult{"docker", preflightFail, msg},
+			preflightResult{"capabilities", preflightFail, msg},
+		)
+	}
+
+	results = append(results, checkFingerprint(ctx, dh.DriverPlugin))
+	results = append(results, checkCapabilities(dh.DriverPlugin))
+
+	return results
+}
+
+func checkPluginBinary() preflightResult {
+	r := preflightResult{Check: "plugin binary"}
+
+	fi, err := os.Stat(pluginPath)
+	switch {
+	case pluginInProcess:
+		r.Status, r.Message = preflightPass, "not needed, driver runs in process"
+	case err != nil:
+		r.Status, r.Message = preflightFail, err.Error()
+	case fi.IsDir():
+		r.Status, r.Message = preflightFail, fmt.Sprintf("%s is a directory", pluginPath)
+	case fi.Mode()&0111 == 0:
+		r.Status, r.Message = preflightFail, fmt.Sprintf("%s is not executable", pluginPath)
+	default:
+		r.Status, r.Message = preflightPass, pluginPath
+	}
+
+	return r
+}
+
+// checkImageTar checks the image tar the run loads. A -load-image tar must be
+// readable, the default busybox.tar is optional.
+func checkImageTar(loadImage string) preflightResult {
+	r := preflightResult{Check: "image tar"}
+
+	if loadImage != "" {
+		if err := checkReadable(loadImage); err != nil {
+			r.Status, r.Message = preflightFail, err.Error()
+			return r
+		}
+		r.Status, r.Message = preflightPass, loadImage
+		return r
+	}
+
+	tar := harness.DefaultImageTar
+	if _, err := os.Stat(tar); err != nil {
+		r.Status, r.Message = preflightWarn, fmt.Sprintf("%s not found, image must already be present in docker or be pulled", tar)
+		return r
+	}
+
+	r.Status, r.Message = preflightPass, tar
+	return r
+}
+
+func checkAllocDir() preflightResult {
+	r := preflightResult{Check: "alloc dir"}
+
+	dir, err := ioutil.TempDir("", harness.AllocDirPrefix)
+	if err != nil {
+		r.Status, r.Message = preflightFail, err.Error()
+		return r
+	}
+	defer os.RemoveAll(dir)
+
+	r.Status, r.Message = preflightPass, fmt.Sprintf("%s is writable", os.TempDir())
+	return r
+}
+
+func checkFingerprint(ctx context.Context, d drivers.DriverPlugin) preflightResult {
+	r := preflightResult{Check: "docker"}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	fp, err := firstFingerprint(ctx, d)
+	switch {
+	case err != nil:
+		r.Status, r.Message = preflightFail, err.Error()
+	case fp.Health != drivers.HealthStateHealthy:
+		r.Status, r.Message = preflightFail, fmt.Sprintf("%s: %s", fp.Health, fp.HealthDescription)
+	default:
+		r.Status, r.Message = preflightPass, fp.HealthDescription
+	}
+
+	return r
+}
+
+func checkCapabilities(d drivers.DriverPlugin) preflightResult {
+	r := preflightResult{Check: "capabilities"}
+
+	caps, err := d.Capabilities()
+	if err != nil {
+		r.Status, r.Message = preflightFail, err.Error()
+		return r
+	}
+
+	// StopTask is always called with a signal, exec is only nice to have.
+	switch {
+	case !caps.SendSignals:
+		r.Status, r.Message = preflightFail, "driver cannot send signals"
+	case !caps.Exec:
+		r.Status, r.Message = preflightWarn, "driver does not support exec"
+	default:
+		r.Status, r.Message = preflightPass, fmt.Sprintf("signals, exec, fs isolation %s", caps.FSIsolation)
+	}
+
+	return r
+}
+
+// printPreflight writes the preflight report as a table.
+func printPreflight(w io.Writer, results []preflightResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHECK\tSTATUS\tMESSAGE")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Check, r.Status, r.Message)
+	}
+	tw.Flush()
+}
+
+// preflightFailed reports whether any check failed.
+func preflightFailed(results []preflightResult) bool {
+	for _, r := range results {
+		if r.Status == preflightFail {
+			return true
+		}
+	}
+	return false
+}
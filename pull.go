@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/nomad/structs"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+const (
+	// maxPullBackoff caps the delay between image pull attempts.
+	maxPullBackoff = 30 * time.Second
+
+	// pullProgressInterval is how often a StartTask still pulling its
+	// image is reported.
+	pullProgressInterval = 10 * time.Second
+)
+
+// taskStarter starts tasks, either a driver or a harness which keeps their
+// handles. Its task events carry the image pull progress.
+type taskStarter interface {
+	StartTask(*drivers.TaskConfig) (*drivers.TaskHandle, *drivers.DriverNetwork, error)
+	TaskEvents(context.Context) (<-chan *drivers.TaskEvent, error)
+}
+
+// startTaskWithPullRetries starts task and retries up to retries more times
+// when StartTask fails with a recoverable image pull error, such as a registry
+// timeout or rate limit. The image pull is the first thing the docker driver
+// does, so a failed pull leaves nothing behind to clean up.
+func startTaskWithPullRetries(d taskStarter, logger hclog.Logger, task *drivers.TaskConfig, retries int) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		handle, dnet, err := startTaskReporting(d, logger, task, os.Stderr)
+		if err == nil || !isPullError(err) {
+			return handle, dnet, err
+		}
+		if isAuthError(err) {
+			return nil, nil, fmt.Errorf("image pull failed, authentication required: give registry credentials with -docker-auth or DOCKER_CONFIG: %v", err)
+		}
+		recoverable := structs.IsRecoverable(err)
+		if strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+			cfg := pullConfig(task)
+			err = fmt.Errorf("image pull of %s timed out after %s: %v", cfg.Image, cfg.ImagePullTimeout, err)
+		}
+		if attempt > retries || !recoverable {
+			return nil, nil, fmt.Errorf("image pull failed after %d attempts: %v", attempt, err)
+		}
+
+		logger.Warn("image pull failed, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxPullBackoff {
+			backoff = maxPullBackoff
+		}
+	}
+}
+
+// startTaskReporting runs StartTask once. While it is in flight and the
+// image is pulled rather than loaded from a tar, the elapsed time and the
+// latest pull progress event of the task are written to w every
+// pullProgressInterval.
+func startTaskReporting(d taskStarter, logger hclog.Logger, task *drivers.TaskConfig, w io.Writer) (*drivers.TaskHandle, *drivers.DriverNetwork, error) {
+	cfg := pullConfig(task)
+	if cfg.Image == "" || cfg.LoadImage != "" {
+		return d.StartTask(task)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	events, err := d.TaskEvents(ctx)
+	if err != nil {
+		logger.Warn("failed to subscribe to image pull progress", "error", err)
+	}
+
+	go func() {
+		defer close(done)
+		start := time.Now()
+		ticker := time.NewTicker(pullProgressInterval)
+		defer ticker.Stop()
+
+		var progress string
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				if ev.TaskID == task.ID && ev.Err == nil {
+					progress = ev.Message
+				}
+			case <-ticker.C:
+				elapsed := time.Since(start).Round(time.Second)
+				logger.Info("still pulling image", "task", task.Name, "image", cfg.Image, "elapsed", elapsed, "progress", progress)
+				if progress != "" {
+					fmt.Fprintf(w, "%s: still pulling image %s (elapsed %s): %s\n", task.Name, cfg.Image, elapsed, progress)
+				} else {
+					fmt.Fprintf(w, "%s: still pulling image %s (elapsed %s)\n", task.Name, cfg.Image, elapsed)
+				}
+			}
+		}
+	}()
+
+	return d.StartTask(task)
+}
+
+// pullConfig returns the docker task config of task, zero for a driver
+// config it does not decode as.
+func pullConfig(task *drivers.TaskConfig) docker.TaskConfig {
+	var cfg docker.TaskConfig
+	if err := task.DecodeDriverConfig(&cfg); err != nil {
+		return docker.TaskConfig{}
+	}
+	return cfg
+}
+
+// isPullError reports whether a StartTask error came from pulling the image.
+func isPullError(err error) bool {
+	return strings.Contains(err.Error(), "Failed to pull `")
+}
+
+// authErrors are what registries answer a pull without valid credentials
+// with, as passed on by the docker daemon.
+var authErrors = []string{
+	"authentication required",
+	"unauthorized",
+	"no basic auth credentials",
+	"requested access to the resource is denied",
+	"may require 'docker login'",
+}
+
+// isAuthError reports whether an image pull error means the registry wants
+// credentials. Registries often hide private images behind "not found", so
+// those are only caught when they hint at a login.
+func isAuthError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range authErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// runRecovered re-attaches to the task saved in path and lets it run until it
+// exits or ctx is done. It then stops the task and removes its alloc dir.
+func runRecovered(ctx context.Context, logger hclog.Logger, dh *harness.DriverHarness, path string, grace time.Duration) ([]shutdownResult, error) {
+	handle, err := harness.LoadTaskHandle(path)
+	if err != nil {
+		return nil, err
+	}
+	task := handle.Config
+
+	if err := dh.RecoverTask(handle); err != nil {
+		return nil, fmt.Errorf("failed to recover task %s: %v", task.ID, err)
+	}
+	fmt.Printf("recovered task %s (%s)\n", task.Name, task.ID)
+
+	if task.AllocDir != "" {
+		ad, err := dh.RecoverLogs(task)
+		if err != nil {
+			logger.Error("failed to recover task logs", "error", err)
+		}
+		if ad != nil {
+			fmt.Printf("re-attached logmon, logs are written to %s\n", ad.LogDir())
+			defer ad.Destroy()
+			defer ad.StopLogs()
+		} else {
+			defer func() {
+				if err := harness.RemoveAllocDir(logger, task.AllocDir); err != nil {
+					logger.Error("failed to remove alloc dir", "path", task.AllocDir, "error", err)
+				}
+			}()
+		}
+	}
+
+	if _, err := dh.WaitTask(ctx, task.ID); err != nil && ctx.Err() == nil {
+		logger.Error("failed to wait on recovered task", "error", err)
+	}
+
+	return Shutdown(context.Background(), dh, []*drivers.TaskConfig{task}, grace, "SIGINT"), nil
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// logPollInterval is how often a log stream checks whether its task is still
+// running.
+const logPollInterval = 500 * time.Millisecond
+
+// taskStatusResponse is the body of GET /tasks/<id>.
+type taskStatusResponse struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExitCode    *int       `json:"exit_code,omitempty"`
+	Signal      int        `json:"signal,omitempty"`
+	OOMKilled   bool       `json:"oom_killed,omitempty"`
+
+	// Stats is the latest resource usage sample, missing until the first
+	// one arrived or when stats are off.
+	Stats *taskStatsResponse `json:"stats,omitempty"`
+}
+
+// taskStatsResponse is a resource usage sample of a task.
+type taskStatsResponse struct {
+	Time       time.Time `json:"time"`
+	CPUPercent float64   `json:"cpu_percent"`
+	MemoryRSS  uint64    `json:"memory_rss"`
+}
+
+// serverTask is a task started through the API. mu serialises stop against
+// status reads and log streams of the same task.
+type serverTask struct {
+	mu      sync.Mutex
+	task    *drivers.TaskConfig
+	ad      *harness.TaskAllocDir
+	grace   time.Duration
+	stopped bool
+
+	// stats is the latest sample of the stats stream, which stopStats
+	// ends.
+	stats     *drivers.TaskResourceUsage
+	stopStats context.CancelFunc
+}
+
+// server exposes the harness over HTTP. All tasks share the dispensed driver
+// of dh and report their state changes to lifecycle.
+type server struct {
+	dh            *harness.DriverHarness
+	lifecycle     *lifecycleNotifier
+	logger        hclog.Logger
+	grace         time.Duration
+	statsInterval time.Duration
+
+	mu    sync.Mutex
+	tasks map[string]*serverTask
+}
+
+// newServer returns a server stopping tasks with grace unless their spec
+// sets a stop timeout. Tasks report stats at statsInterval, never if it is
+// not positive.
+func newServer(logger hclog.Logger, dh *harness.DriverHarness, lifecycle *lifecycleNotifier, grace, statsInterval time.Duration) *server {
+	return &server{
+		dh:            dh,
+		lifecycle:     lifecycle,
+		logger:        logger.Named("server"),
+		grace:         grace,
+		statsInterval: statsInterval,
+		tasks:         make(map[string]*serverTask),
+	}
+}
+
+// Serve runs the API on addr until ctx is done, then stops every task it
+// started.
+func (s *server) Serve(ctx context.Context, addr string) ([]shutdownResult, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.serve(ctx, ln)
+}
+
+func (s *server) serve(ctx context.Context, ln net.Listener) ([]shutdownResult, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tasks", s.handleTasks)
+	mux.HandleFunc("/tasks/", s.handleTask)
+
+	// Shutdown does not wait for log streams to end on their own, requests
+	// are cancelled with baseCtx instead.
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv := &http.Server{
+		Handler:     mux,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(cancelRequests)
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(ln)
+	}()
+	s.logger.Info("serving", "addr", ln.Addr().String())
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = srv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+
+	return s.stopAll(), err
+}
+
+// stopAll stops every running task concurrently.
+func (s *server) stopAll() []shutdownResult {
+	s.mu.Lock()
+	tasks := make([]*serverTask, 0, len(s.tasks))
+	for _, st := range s.tasks {
+		tasks = append(tasks, st)
+	}
+	s.mu.Unlock()
+
+	var (
+		results []shutdownResult
+		resMu   sync.Mutex
+		wg      sync.WaitGroup
+	)
+	for _, st := range tasks {
+		wg.Add(1)
+		go func(st *serverTask) {
+			defer wg.Done()
+			// a task stopped through the API meanwhile was already reported
+			res, err := s.stop(st)
+			if err != nil {
+				return
+			}
+			resMu.Lock()
+			results = append(results, res)
+			resMu.Unlock()
+		}(st)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// handleTasks serves GET /tasks and POST /tasks.
+func (s *server) handleTasks(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		ids := make([]string, 0, len(s.tasks))
+		for id := range s.tasks {
+			ids = append(ids, id)
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, ids)
+	case http.MethodPost:
+		s.handleStart(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleTask serves GET and DELETE /tasks/<id> and GET /tasks/<id>/logs.
+func (s *server) handleTask(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/tasks/"), "/")
+	if len(parts) > 2 || (len(parts) == 2 && parts[1] != "logs") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	}
+
+	s.mu.Lock()
+	st, ok := s.tasks[parts[0]]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("task %q not found", parts[0]))
+		return
+	}
+
+	switch {
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.handleLogs(w, r, st)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.handleStatus(w, st)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		res, err := s.stop(st)
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newResultLine(res))
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleStart starts the task described by the harness.TaskSpec in the body,
+// running the default command if it has none.
+func (s *server) handleStart(w http.ResponseWriter, r *http.Request) {
+	var spec harness.TaskSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid task spec: %v", err))
+		return
+	}
+	if spec.Name == "." || spec.Name == ".." || strings.ContainsAny(spec.Name, `/\`) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid task name %q", spec.Name))
+		return
+	}
+	if spec.Command == "" {
+		def := harness.DefaultTaskSpec()
+		spec.Command, spec.Args = def.Command, def.Args
+	}
+
+	task, taskCfg, err := s.dh.NewTask(spec)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.dh.EncodeTaskConfig(task, taskCfg)
+
+	ad, err := s.dh.BuildAllocDir(task, true, "", "")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	taskCfg.Args = expandTaskEnv(taskCfg.Args, task.Env)
+	s.dh.EncodeTaskConfig(task, taskCfg)
+
+	if _, _, err := s.dh.StartTask(task); err != nil {
+		ad.StopLogs()
+		ad.Destroy()
+		s.lifecycle.transition(task, stateFailed, nil, err)
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to start task: %v", err))
+		return
+	}
+	s.lifecycle.transition(task, stateStarted, nil, nil)
+
+	st := &serverTask{task: task, ad: ad, grace: s.grace, stopStats: func() {}}
+	if spec.StopTimeout > 0 {
+		st.grace = spec.StopTimeout
+	}
+	s.collectStats(st)
+
+	s.mu.Lock()
+	s.tasks[task.ID] = st
+	s.mu.Unlock()
+
+	s.logger.Info("started task", "task", task.Name, "id", task.ID)
+	writeJSON(w, http.StatusCreated, map[string]string{"id": task.ID})
+}
+
+// collectStats keeps the latest stats sample of st until it is stopped. A
+// task whose stats cannot be streamed is served without them.
+func (s *server) collectStats(st *serverTask) {
+	if s.statsInterval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := s.dh.StreamStats(ctx, st.task.ID, s.statsInterval)
+	if err != nil {
+		cancel()
+		s.logger.Warn("task stats unavailable", "task", st.task.Name, "id", st.task.ID, "error", err)
+		return
+	}
+	st.stopStats = cancel
+
+	go func() {
+		for u := range ch {
+			st.mu.Lock()
+			st.stats = u
+			st.mu.Unlock()
+		}
+	}()
+}
+
+func (s *server) handleStatus(w http.ResponseWriter, st *serverTask) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.stopped {
+		writeError(w, http.StatusNotFound, fmt.Errorf("task %q not found", st.task.ID))
+		return
+	}
+
+	status, err := s.dh.InspectTask(st.task.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to inspect task: %v", err))
+		return
+	}
+
+	resp := taskStatusResponse{
+		ID:        status.ID,
+		Name:      status.Name,
+		State:     string(status.State),
+		StartedAt: status.StartedAt,
+	}
+	if status.State == drivers.TaskStateExited {
+		resp.CompletedAt = &status.CompletedAt
+	}
+	if status.ExitResult != nil {
+		code := status.ExitResult.ExitCode
+		resp.ExitCode = &code
+		resp.Signal = status.ExitResult.Signal
+		resp.OOMKilled = status.ExitResult.OOMKilled
+	}
+	if u := st.stats; u != nil && u.ResourceUsage != nil {
+		resp.Stats = &taskStatsResponse{Time: time.Unix(0, u.Timestamp)}
+		if cs := u.ResourceUsage.CpuStats; cs != nil {
+			resp.Stats.CPUPercent = cs.Percent
+		}
+		if ms := u.ResourceUsage.MemoryStats; ms != nil {
+			resp.Stats.MemoryRSS = ms.RSS
+		}
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleLogs streams the task output as server-sent events, one event per
+// line. ?stream=stderr selects stderr instead of stdout. The stream ends
+// with an exit event once the task has exited or been stopped.
+func (s *server) handleLogs(w http.ResponseWriter, r *http.Request, st *serverTask) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	file := st.ad.StdoutFile()
+	switch r.URL.Query().Get("stream") {
+	case "", "stdout":
+	case "stderr":
+		file = st.ad.StderrFile()
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("stream must be stdout or stderr"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The tail writes to the response until the task ends, then flushes a
+	// last line the task did not terminate.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	tailDone := make(chan error, 1)
+	go func() {
+		tailDone <- st.ad.FollowLog(ctx, &sseWriter{w: w, flusher: flusher}, file)
+	}()
+
+	ticker := time.NewTicker(logPollInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-r.Context().Done():
+			<-tailDone
+			return
+		case <-tailDone:
+			// the log files are gone
+			writeExitEvent(w, flusher)
+			return
+		case <-ticker.C:
+			st.mu.Lock()
+			stopped := st.stopped
+			st.mu.Unlock()
+			if stopped {
+				running = false
+				break
+			}
+			status, err := s.dh.InspectTask(st.task.ID)
+			running = err == nil && status.State != drivers.TaskStateExited
+		}
+	}
+	cancel()
+	<-tailDone
+	writeExitEvent(w, flusher)
+}
+
+func writeExitEvent(w io.Writer, flusher http.Flusher) {
+	fmt.Fprint(w, "event: exit\ndata: {}\n\n")
+	flusher.Flush()
+}
+
+// sseWriter writes every line of the whole lines it is given as a
+// server-sent event.
+type sseWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func (sw *sseWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.SplitAfter(string(p), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(sw.w, "data: %s\n\n", strings.TrimSuffix(line, "\n")); err != nil {
+			return 0, err
+		}
+	}
+	sw.flusher.Flush()
+	return len(p), nil
+}
+
+// stop shuts the task down and removes it along with its alloc dir. Stopping
+// a task twice returns an error.
+func (s *server) stop(st *serverTask) (shutdownResult, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.stopped {
+		return shutdownResult{}, fmt.Errorf("task %q not found", st.task.ID)
+	}
+
+	st.stopStats()
+	res := Shutdown(context.Background(), s.dh, []*drivers.TaskConfig{st.task}, st.grace, "SIGINT")[0]
+	s.lifecycle.shutdown([]shutdownResult{res})
+	st.ad.StopLogs()
+	st.ad.Destroy()
+	st.stopped = true
+
+	s.mu.Lock()
+	delete(s.tasks, st.task.ID)
+	s.mu.Unlock()
+
+	s.logger.Info("stopped task", "task", st.task.Name, "id", st.task.ID, "outcome", res.Outcome)
+	return res, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
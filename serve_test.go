@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+func TestServeShutdownWithLogStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := hclog.NewNullLogger()
+	dh := harness.NewInProcess(logger, mockdriver.NewWithConfig(ctx, logger, mockdriver.Config{RunFor: time.Hour}))
+	defer dh.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveCtx, stopServe := context.WithCancel(ctx)
+	defer stopServe()
+
+	type serveResult struct {
+		results []shutdownResult
+		err     error
+	}
+	doneCh := make(chan serveResult, 1)
+	go func() {
+		results, err := newServer(logger, dh, nil, time.Second, 0).serve(serveCtx, ln)
+		doneCh <- serveResult{results, err}
+	}()
+
+	url := fmt.Sprintf("http://%s/tasks", ln.Addr())
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{"name": "streamed"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started map[string]string
+	err = json.NewDecoder(resp.Body).Decode(&started)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("failed to start task: %s %v %v", resp.Status, started, err)
+	}
+
+	stream, err := http.Get(url + "/" + started["id"] + "/logs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	if stream.StatusCode != http.StatusOK {
+		t.Fatalf("failed to open log stream: %s", stream.Status)
+	}
+	go bufio.NewReader(stream.Body).WriteTo(new(strings.Builder))
+
+	stopServe()
+	select {
+	case r := <-doneCh:
+		if r.err != nil {
+			t.Errorf("failed to shut down with an open log stream: %v", r.err)
+		}
+		if len(r.results) != 1 || r.results[0].Outcome != outcomeStopped {
+			t.Errorf("shutdown results %+v, expected the stopped task", r.results)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown blocked on the open log stream")
+	}
+}
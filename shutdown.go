@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// taskOutcome describes how a task ended during shutdown.
+type taskOutcome string
+
+const (
+	// outcomeExited is a task which had already exited on its own.
+	outcomeExited taskOutcome = "already-exited"
+	// outcomeStopped is a task which was still running and exited on the
+	// stop signal within the grace period.
+	outcomeStopped taskOutcome = "stopped"
+	// outcomeTimedOut is a task which ignored the stop signal and exited
+	// once the driver killed it at the end of the grace period.
+	outcomeTimedOut taskOutcome = "timed-out-then-stopped"
+	// outcomeKilled is a task which did not exit within the grace period
+	// and was force destroyed.
+	outcomeKilled taskOutcome = "killed"
+	// outcomeFailed is a task which could not be stopped.
+	outcomeFailed taskOutcome = "stop-failed"
+	// outcomeStartFailed is a task which never started.
+	outcomeStartFailed taskOutcome = "start-failed"
+)
+
+// shutdownResult is how a single task ended.
+type shutdownResult struct {
+	TaskID  string
+	Name    string
+	Outcome taskOutcome
+	Exit    *drivers.ExitResult
+	Err     error
+	// Status is the task as inspected before it was stopped.
+	Status *drivers.TaskStatus
+}
+
+// Shutdown stops every task, waits up to grace for each to exit, destroys
+// it and reports how it ended. Tasks are stopped concurrently through the
+// harness, which forgets their handles.
+func Shutdown(ctx context.Context, dh *harness.DriverHarness, tasks []*drivers.TaskConfig, grace time.Duration, signal string) []shutdownResult {
+	results := make([]shutdownResult, len(tasks))
+
+	var wg sync.WaitGroup
+	for i, task := range tasks {
+		wg.Add(1)
+		go func(i int, task *drivers.TaskConfig) {
+			defer wg.Done()
+			results[i] = shutdownTask(ctx, dh, task, grace, signal)
+		}(i, task)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func shutdownTask(ctx context.Context, dh *harness.DriverHarness, task *drivers.TaskConfig, grace time.Duration, signal string) shutdownResult {
+	r := shutdownResult{TaskID: task.ID, Name: task.Name}
+
+	defer func() {
+		if err := dh.DestroyTask(task.ID, true); err != nil && r.Err == nil {
+			r.Err = fmt.Errorf("failed to destroy task: %v", err)
+		}
+	}()
+
+	status, err := dh.InspectTask(task.ID)
+	if err != nil {
+		r.Outcome, r.Err = outcomeFailed, fmt.Errorf("failed to inspect task: %v", err)
+		return r
+	}
+	r.Status = status
+	if status.State == drivers.TaskStateExited {
+		r.Outcome, r.Exit = outcomeExited, status.ExitResult
+		return r
+	}
+
+	// Waiting starts before the stop, the driver keeps the exit result
+	// until the task is destroyed so the exit can't be missed.
+	waitCtx, cancel := context.WithTimeout(ctx, grace+5*time.Second)
+	defer cancel()
+
+	type waitResult struct {
+		res *drivers.ExitResult
+		err error
+		at  time.Time
+	}
+	exitCh := make(chan waitResult, 1)
+	go func() {
+		res, err := dh.WaitTask(waitCtx, task.ID)
+		exitCh <- waitResult{res, err, time.Now()}
+	}()
+
+	stopAt := time.Now()
+	if err := dh.StopTask(task.ID, grace, signal); err != nil {
+		r.Outcome, r.Err = outcomeFailed, fmt.Errorf("failed to stop task: %v", err)
+		return r
+	}
+
+	w := <-exitCh
+	switch {
+	case w.err == nil && w.at.Sub(stopAt) < grace:
+		r.Outcome, r.Exit = outcomeStopped, w.res
+	case w.err == nil:
+		r.Outcome, r.Exit = outcomeTimedOut, w.res
+	case waitCtx.Err() != nil:
+		r.Outcome = outcomeKilled
+	default:
+		r.Outcome, r.Err = outcomeKilled, w.err
+	}
+
+	return r
+}
+
+// printShutdown writes the end of run report as a table.
+func printShutdown(w io.Writer, results []shutdownResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tID\tOUTCOME\tEXIT CODE\tSIGNAL\tOOM\tERROR")
+	for _, r := range results {
+		code, sig, oom := "-", "-", "-"
+		if r.Exit != nil {
+			code = fmt.Sprint(r.Exit.ExitCode)
+			sig = fmt.Sprint(r.Exit.Signal)
+			oom = fmt.Sprint(r.Exit.OOMKilled)
+		}
+		errMsg := "-"
+		if r.Err != nil {
+			errMsg = r.Err.Error()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", r.Name, r.TaskID, r.Outcome, code, sig, oom, errMsg)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+// stubbornDriver is a mock driver whose tasks ignore the stop signal, so
+// StopTask kills them once the timeout is up as docker does.
+type stubbornDriver struct {
+	*mockdriver.Driver
+}
+
+func (d *stubbornDriver) StopTask(taskID string, timeout time.Duration, signal string) error {
+	time.Sleep(timeout)
+	return d.SignalTask(taskID, "SIGKILL")
+}
+
+func TestShutdownOutcome(t *testing.T) {
+	cases := []struct {
+		name     string
+		stubborn bool
+		outcome  taskOutcome
+		signal   int
+	}{
+		{
+			name:    "honours signal",
+			outcome: outcomeStopped,
+			signal:  2,
+		},
+		{
+			name:     "ignores signal",
+			stubborn: true,
+			outcome:  outcomeTimedOut,
+			signal:   9,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			logger := hclog.NewNullLogger()
+			var d drivers.DriverPlugin = mockdriver.NewWithConfig(ctx, logger, mockdriver.Config{RunFor: time.Hour})
+			if c.stubborn {
+				d = &stubbornDriver{Driver: d.(*mockdriver.Driver)}
+			}
+			dh := harness.NewInProcess(logger, d)
+			defer dh.Close()
+
+			task, taskCfg, err := dh.NewTask(harness.DefaultTaskSpec())
+			if err != nil {
+				t.Fatal(err)
+			}
+			dh.EncodeTaskConfig(task, taskCfg)
+			if _, _, err := dh.StartTask(task); err != nil {
+				t.Fatalf("failed to start task: %v", err)
+			}
+
+			r := Shutdown(ctx, dh, []*drivers.TaskConfig{task}, 200*time.Millisecond, "SIGINT")[0]
+			if r.Err != nil {
+				t.Fatalf("failed to shut down task: %v", r.Err)
+			}
+			if r.Outcome != c.outcome {
+				t.Errorf("outcome %s, expected %s", r.Outcome, c.outcome)
+			}
+			if r.Exit == nil || r.Exit.Signal != c.signal {
+				t.Errorf("exit %+v, expected signal %d", r.Exit, c.signal)
+			}
+			if _, ok := dh.Handle(task.ID); ok {
+				t.Error("harness kept the handle of the shut down task")
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/hashicorp/nomad/client/allocdir"
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/helper/uuid"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+)
+
+// sidecarName is the name of the log shipping sidecar task.
+const sidecarName = "log-shipper"
+
+// newSidecarTask returns a task which follows the stdout and stderr logs of
+// main through the shared alloc dir and ships them to its own stdout. It must
+// be placed in the alloc dir of main with MkSidecarDir.
+func newSidecarTask(dh *harness.DriverHarness, main *drivers.TaskConfig, stdoutFile, stderrFile string) (*drivers.TaskConfig, docker.TaskConfig) {
+	logDir := path.Join(allocdir.SharedAllocContainerPath, allocdir.LogDirName)
+
+	task := &drivers.TaskConfig{
+		ID:        uuid.Generate(),
+		Name:      sidecarName,
+		AllocID:   main.AllocID,
+		Resources: dh.Resources(),
+	}
+
+	// logmon writes <file>.0 first, see harness.ReadLogFiles
+	cfg := dh.TaskConfig([]string{
+		"tail", "-F",
+		path.Join(logDir, fmt.Sprintf("%s.0", stdoutFile)),
+		path.Join(logDir, fmt.Sprintf("%s.0", stderrFile)),
+	})
+
+	return task, cfg
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// printStats writes the CPU percent and memory RSS of a stats sample.
+func printStats(w io.Writer, u *drivers.TaskResourceUsage) {
+	if u == nil || u.ResourceUsage == nil {
+		return
+	}
+
+	cpu, rss := "-", "-"
+	if cs := u.ResourceUsage.CpuStats; cs != nil {
+		cpu = fmt.Sprintf("%.2f%%", cs.Percent)
+	}
+	if ms := u.ResourceUsage.MemoryStats; ms != nil {
+		rss = fmt.Sprintf("%.1f MiB", float64(ms.RSS)/(1024*1024))
+	}
+
+	fmt.Fprintf(w, "%s cpu: %s rss: %s\n", time.Unix(0, u.Timestamp).Format(time.RFC3339), cpu, rss)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl"
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclspecutils"
+	"github.com/hashicorp/nomad/helper/pluginutils/hclutils"
+	"github.com/hashicorp/nomad/plugins/base"
+	"github.com/hashicorp/nomad/plugins/drivers"
+	"github.com/hashicorp/nomad/plugins/shared/hclspec"
+	"github.com/mitchellh/mapstructure"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/harness"
+	"github.com/zclconf/go-cty/cty/msgpack"
+)
+
+// taskCommand returns the command line for the task. In exec form the
+// command is split on whitespace and run directly, so there are no pipes,
+// redirects or variable expansion. In shell form it is passed as a single
+// string to /bin/sh -c. An empty command runs the default nc listener.
+func taskCommand(command string, shell bool) ([]string, error) {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		if shell {
+			return nil, fmt.Errorf("-shell requires a -command string")
+		}
+		spec := harness.DefaultTaskSpec()
+		return append([]string{spec.Command}, spec.Args...), nil
+	}
+
+	if shell {
+		return []string{"/bin/sh", "-c", command}, nil
+	}
+	return strings.Fields(command), nil
+}
+
+// loadTaskConfig reads a docker task config from path and decodes it with
+// the task config schema of the driver, the same way Nomad decodes the config
+// block of a job. Files ending in .json are read as JSON, anything else as
+// HCL. Both hold the body of the config block:
+//
+//	image   = "redis:6"
+//	command = "redis-server"
+func loadTaskConfig(d drivers.DriverPlugin, path string) (docker.TaskConfig, error) {
+	var tc docker.TaskConfig
+
+	schema, err := d.TaskConfigSchema()
+	if err != nil {
+		return tc, fmt.Errorf("failed to get task config schema: %v", err)
+	}
+	if err := decodeConfigFile(path, "task config", schema, &tc); err != nil {
+		return tc, err
+	}
+
+	switch {
+	case tc.Image == "":
+		return tc, fmt.Errorf("task config %s: image is required", path)
+	case tc.Command == "":
+		return tc, fmt.Errorf("task config %s: command is required", path)
+	}
+
+	return tc, nil
+}
+
+// decodeConfigFile reads the HCL or, for files ending in .json, JSON config
+// body in path, decodes it with schema and stores the result in out, which
+// must have codec tags matching the schema. kind names the config in errors.
+func decodeConfigFile(path, kind string, schema *hclspec.Spec, out interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", kind, err)
+	}
+
+	var raw map[string]interface{}
+	if filepath.Ext(path) == ".json" {
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return fmt.Errorf("failed to parse %s %s as JSON: %v", kind, path, err)
+		}
+	} else {
+		var m map[string]interface{}
+		if err := hcl.Unmarshal(b, &m); err != nil {
+			return fmt.Errorf("failed to parse %s %s as HCL: %v", kind, path, err)
+		}
+		// HCL decodes every block as a list of maps, weak decoding flattens
+		// them as the job parser does.
+		if err := mapstructure.WeakDecode(m, &raw); err != nil {
+			return fmt.Errorf("failed to parse %s %s as HCL: %v", kind, path, err)
+		}
+	}
+
+	spec, diags := hclspecutils.Convert(schema)
+	if diags.HasErrors() {
+		return fmt.Errorf("invalid %s schema: %v", kind, diags)
+	}
+
+	val, diags, errs := hclutils.ParseHclInterface(raw, spec, nil)
+	if diags.HasErrors() {
+		msgs := make([]string, 0, len(errs))
+		for _, err := range errs {
+			msgs = append(msgs, err.Error())
+		}
+		return fmt.Errorf("invalid %s %s: %s", kind, path, strings.Join(msgs, "; "))
+	}
+
+	data, err := msgpack.Marshal(val, val.Type())
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %v", kind, err)
+	}
+	if err := base.MsgPackDecode(data, out); err != nil {
+		return fmt.Errorf("failed to decode %s: %v", kind, err)
+	}
+	return nil
+}
+
+// applyPidsLimit sets the -pids-limit flag value on cfg. A pids_limit from a
+// task config file is only overridden when the flag was given.
+func applyPidsLimit(cfg *docker.TaskConfig, limit int64, fromFile, flagSet bool) {
+	if !fromFile || flagSet {
+		cfg.PidsLimit = limit
+	}
+}
+
+// sysctlKeyRe matches kernel parameter names such as net.core.somaxconn.
+var sysctlKeyRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+([./][a-zA-Z0-9_-]+)+$`)
+
+// validateSysctls checks that all sysctl keys look like kernel parameters.
+func validateSysctls(sysctls map[string]string) error {
+	for k := range sysctls {
+		if !sysctlKeyRe.MatchString(k) {
+			return fmt.Errorf("invalid sysctl key %q", k)
+		}
+	}
+	return nil
+}
+
+// verifySysctls reads back every sysctl from inside the running task.
+func verifySysctls(d drivers.DriverPlugin, taskID string, sysctls map[string]string) error {
+	for k, want := range sysctls {
+		res, err := d.ExecTask(taskID, []string{"sysctl", "-n", k}, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to read sysctl %q: %v", k, err)
+		}
+		// multi-value sysctls are printed tab separated
+		got := strings.Join(strings.Fields(string(res.Stdout)), " ")
+		if got != strings.Join(strings.Fields(want), " ") {
+			return fmt.Errorf("sysctl %q is %q, expected %q", k, got, want)
+		}
+	}
+	return nil
+}
+
+// verifyPidsLimit tries to fork more than limit processes inside the task
+// and reports an error if that succeeds.
+func verifyPidsLimit(d drivers.DriverPlugin, taskID string, limit int64) error {
+	script := fmt.Sprintf("for i in $(seq 1 %d); do sleep 2 & done; wait", limit+1)
+	res, err := d.ExecTask(taskID, []string{"/bin/sh", "-c", script}, 10*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to exec pids limit check: %v", err)
+	}
+	if res.ExitResult != nil && res.ExitResult.Successful() && len(res.Stderr) == 0 {
+		return fmt.Errorf("started %d processes despite pids limit %d", limit+1, limit)
+	}
+	return nil
+}
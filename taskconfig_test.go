@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/mjudeikis/go-plugin-hashi-exampe/pkg/mockdriver"
+)
+
+func TestApplyPidsLimit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "task.hcl")
+	cfgFile := "image = \"busybox:1\"\ncommand = \"nc\"\npids_limit = 64\n"
+	if err := ioutil.WriteFile(path, []byte(cfgFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := mockdriver.New(ctx, hclog.NewNullLogger())
+
+	cases := []struct {
+		name     string
+		fromFile bool
+		flagSet  bool
+		flag     int64
+		limit    int64
+	}{
+		{"file kept over flag default", true, false, 0, 64},
+		{"file overridden by flag", true, true, 32, 32},
+		{"flag without file", false, false, 16, 16},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			taskCfg, err := loadTaskConfig(d, path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c.fromFile {
+				taskCfg.PidsLimit = 0
+			}
+
+			applyPidsLimit(&taskCfg, c.flag, c.fromFile, c.flagSet)
+			if taskCfg.PidsLimit != c.limit {
+				t.Errorf("pids limit %d, expected %d", taskCfg.PidsLimit, c.limit)
+			}
+		})
+	}
+}
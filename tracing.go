@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+)
+
+// tracingServiceName is the service the harness spans are reported under.
+const tracingServiceName = "nomad-driver-harness"
+
+// setupTracing exports the harness spans over OTLP/HTTP to endpoint, a
+// host:port without TLS such as a local collector. An empty endpoint leaves
+// the global no-op tracer in place. The returned func flushes the spans
+// still buffered and must be called before exiting.
+//
+// The trace context of the driver calls is passed on to the plugin in the
+// gRPC metadata as a W3C traceparent.
+func setupTracing(endpoint string) func(context.Context) error {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(newOTLPExporter(endpoint)),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(tracingServiceName),
+		)),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown
+}
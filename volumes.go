@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/nomad/drivers/docker"
+	"github.com/hashicorp/nomad/plugins/drivers"
+)
+
+// volumeMount is a host path bind mounted into the container.
+type volumeMount struct {
+	Host      string
+	Container string
+	ReadOnly  bool
+}
+
+// volumeFlags collects repeated -volume flags of the form
+// host:container[:ro|rw].
+type volumeFlags []volumeMount
+
+func (v *volumeFlags) String() string {
+	parts := make([]string, 0, len(*v))
+	for _, m := range *v {
+		mode := "rw"
+		if m.ReadOnly {
+			mode = "ro"
+		}
+		parts = append(parts, fmt.Sprintf("%s:%s:%s", m.Host, m.Container, mode))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (v *volumeFlags) Set(s string) error {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid volume %q, want host:container[:ro|rw]", s)
+	}
+
+	m := volumeMount{Host: parts[0], Container: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return fmt.Errorf("invalid volume mode %q in %q, want ro or rw", parts[2], s)
+		}
+	}
+
+	if !filepath.IsAbs(m.Container) {
+		return fmt.Errorf("container path %q of volume %q must be absolute", m.Container, s)
+	}
+	host, err := filepath.Abs(m.Host)
+	if err != nil {
+		return fmt.Errorf("invalid host path %q: %v", m.Host, err)
+	}
+	if err := checkReadable(host); err != nil {
+		return fmt.Errorf("host path of volume %q: %v", s, err)
+	}
+	m.Host = host
+
+	*v = append(*v, m)
+	return nil
+}
+
+// checkReadable returns a descriptive error unless path exists and can be
+// read by the harness.
+func checkReadable(path string) error {
+	f, err := os.Open(path)
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("%s does not exist", path)
+	case os.IsPermission(err):
+		return fmt.Errorf("%s is not readable", path)
+	case err != nil:
+		return err
+	}
+	return f.Close()
+}
+
+// dockerMounts returns the docker bind mounts of the volumes.
+func (v volumeFlags) dockerMounts() []docker.DockerMount {
+	mounts := make([]docker.DockerMount, 0, len(v))
+	for _, m := range v {
+		mounts = append(mounts, docker.DockerMount{
+			Type:     "bind",
+			Source:   m.Host,
+			Target:   m.Container,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	return mounts
+}
+
+// checkMountSupport errors if the driver does not accept mounts.
+func checkMountSupport(d drivers.DriverPlugin) error {
+	caps, err := d.Capabilities()
+	if err != nil {
+		return fmt.Errorf("failed to query driver capabilities: %v", err)
+	}
+	if caps.MountConfigs == drivers.MountConfigSupportNone {
+		return fmt.Errorf("driver does not support mounts")
+	}
+	return nil
+}
+
+// verifyReadOnly checks every read-only volume cannot be written to from
+// inside the task.
+func verifyReadOnly(d drivers.DriverPlugin, taskID string, volumes volumeFlags) error {
+	for _, m := range volumes {
+		if !m.ReadOnly {
+			continue
+		}
+		res, err := d.ExecTask(taskID, []string{"test", "-w", m.Container}, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("failed to check volume %s: %v", m.Container, err)
+		}
+		if res.ExitResult != nil && res.ExitResult.Successful() {
+			return fmt.Errorf("read-only volume %s is writable in the task", m.Container)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the body when a webhook
+// secret is configured.
+const signatureHeader = "X-Harness-Signature-256"
+
+// runResult is the JSON document describing how a run ended, one -output
+// json line per task.
+type runResult struct {
+	Tasks []stateLine `json:"tasks"`
+}
+
+func newRunResult(results []shutdownResult) runResult {
+	r := runResult{Tasks: make([]stateLine, 0, len(results))}
+	for _, res := range results {
+		r.Tasks = append(r.Tasks, newResultLine(res))
+	}
+	return r
+}
+
+// webhook POSTs JSON documents to an HTTP endpoint, retrying transient
+// failures.
+type webhook struct {
+	url     string
+	secret  string
+	retries int
+	client  *http.Client
+	logger  hclog.Logger
+}
+
+// newWebhook validates rawURL and returns a webhook posting to it.
+func newWebhook(rawURL, secret string, logger hclog.Logger) (*webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q, expected http(s)://host/path", rawURL)
+	}
+
+	return &webhook{
+		url:     rawURL,
+		secret:  secret,
+		retries: 3,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+	}, nil
+}
+
+// post sends v as JSON. Connection errors, 429 and 5xx responses are retried
+// with backoff, other responses are final.
+func (w *webhook) post(ctx context.Context, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	backoff := time.Second
+	var lastErr error
+	for attempt := 1; attempt <= w.retries; attempt++ {
+		retry, err := w.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == w.retries {
+			break
+		}
+
+		w.logger.Warn("webhook delivery failed, retrying", "url", w.url, "attempt", attempt, "error", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("webhook %s: %v", w.url, lastErr)
+}
+
+// send makes a single delivery attempt and reports whether a failure is worth
+// retrying.
+func (w *webhook) send(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}